@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// maxSuggestions is how many "did you mean" authors are offered at most
+const maxSuggestions = 3
+
+// authorStats is one name and email seen in commits, as -authors lists them
+// A commit shared by several clones is counted once, but every repository it is in is listed
+type authorStats struct {
+	Name         string   `json:"name"`
+	Email        string   `json:"email"`
+	Commits      int      `json:"commits"`
+	First        string   `json:"first"`
+	Last         string   `json:"last"`
+	Repositories []string `json:"repositories"`
+
+	first, last civilDate
+}
+
+// repoAuthors is what one repository contributes to the author list
+type repoAuthors struct {
+	order   []plumbing.Hash
+	commits map[plumbing.Hash]*cachedCommit
+}
+
+// listAuthors is the -authors command: every author of every registered repository
+// It walks the same refs and skips the same repositories as stats, but looks at
+// the whole history rather than the date range, and at every author
+// Returns false when some repositories couldn't be read and the list is partial
+func listAuthors(paths configPaths, opts statsOptions) bool {
+	// filterEntries is defined in tags.go, resolveIdentities and groupProjects in project.go
+	reg := mustLoadRegistry(paths)
+	repos := filterEntries(reg.Repos, opts.filter)
+	resolveIdentities(paths, repos, opts.cacheDir, opts.jobs)
+	var entries []*registryEntry
+	for _, project := range groupProjects(repos) {
+		entries = append(entries, project.entries...)
+	}
+	authors, failures := collectAuthors(entries, opts)
+
+	if opts.json {
+		report := struct {
+			Authors  []*authorStats `json:"authors"`
+			Failures []repoFailure  `json:"failed_repositories"`
+		}{Authors: authors, Failures: failures}
+		if report.Authors == nil {
+			report.Authors = []*authorStats{}
+		}
+		if report.Failures == nil {
+			report.Failures = []repoFailure{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return len(failures) == 0
+	}
+
+	noun := "authors"
+	if len(authors) == 1 {
+		noun = "author"
+	}
+	fmt.Printf("%d %s in %d registered repositories:\n\n", len(authors), noun, len(entries)-len(failures))
+	fmt.Printf("%7s  %-10s  %-10s  %s\n", "Commits", "First", "Last", "Author")
+	for _, a := range authors {
+		fmt.Printf("%7s  %s  %s  %s <%s>\n", formatCount(a.Commits), a.First, a.Last, a.Name, a.Email)
+		fmt.Printf("%7s  in %s\n", "", strings.Join(a.Repositories, ", "))
+	}
+	fmt.Println()
+	printFailures(failures)
+	return len(failures) == 0
+}
+
+// collectAuthors reads every repository, -jobs at a time, and adds up commits per author
+// Authors are the name and email as recorded in the commits, before any .mailmap,
+// since that is what -email and the identities file are matched against
+// The list is sorted by commits, most first
+func collectAuthors(entries []*registryEntry, opts statsOptions) ([]*authorStats, []repoFailure) {
+	found := make([]*repoAuthors, len(entries))
+	errs := make([]error, len(entries))
+	runJobs(len(entries), opts.jobs, func(i int) {
+		found[i], errs[i] = readAuthors(entries[i], opts)
+	})
+
+	// Merge in registry order like mergeHistory does, so shared commits count once
+	byKey := make(map[string]*authorStats)
+	seen := make(map[plumbing.Hash]bool)
+	var authors []*authorStats
+	for i, repo := range found {
+		if repo == nil {
+			continue
+		}
+		name := entries[i].displayName()
+		for _, hash := range repo.order {
+			c := repo.commits[hash]
+			key := c.Name + "\x00" + strings.ToLower(c.Email)
+			a, ok := byKey[key]
+			if !ok {
+				a = &authorStats{Name: c.Name, Email: c.Email}
+				byKey[key] = a
+				authors = append(authors, a)
+			}
+			if !containsString(a.Repositories, name) {
+				a.Repositories = append(a.Repositories, name)
+			}
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+
+			day := dateOf(c.When.In(opts.location))
+			if a.Commits == 0 || day.before(a.first) {
+				a.first = day
+			}
+			if a.Commits == 0 || day.after(a.last) {
+				a.last = day
+			}
+			a.Commits++
+		}
+	}
+
+	for _, a := range authors {
+		a.First, a.Last = a.first.String(), a.last.String()
+	}
+	sort.SliceStable(authors, func(i, j int) bool {
+		return authors[i].Commits > authors[j].Commits
+	})
+	return authors, collectFailures(entries, errs)
+}
+
+// readAuthors walks one repository's history for collectAuthors
+func readAuthors(entry *registryEntry, opts statsOptions) (found *repoAuthors, err error) {
+	defer recoverFailure(&err)
+
+	r := entry.repo()
+	repo, err := openRepository(r)
+	if err != nil {
+		return nil, fmt.Errorf("can't open repository: %w", err)
+	}
+	// readHistory and saveCache are defined in stats.go
+	cache, order, err := readHistory(repo, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	saveCache(cache, r)
+	return &repoAuthors{order: order, commits: cache.Commits}, nil
+}
+
+// suggestAuthors finds the authors whose email looks like one that was asked for
+// An email is close when it, or the part before the @, is a few typos away, or when
+// the name contains that part; the closest come first, then the busiest
+func suggestAuthors(emails []string, authors []*authorStats) []*authorStats {
+	type candidate struct {
+		author   *authorStats
+		distance int
+	}
+	var candidates []candidate
+	for _, a := range authors {
+		best := -1
+		for _, email := range emails {
+			d := emailDistance(email, a)
+			if best < 0 || d < best {
+				best = d
+			}
+		}
+		if best >= 0 {
+			candidates = append(candidates, candidate{a, best})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var suggestions []*authorStats
+	for _, c := range candidates {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+		suggestions = append(suggestions, c.author)
+	}
+	return suggestions
+}
+
+// emailDistance says how far an author is from an email that was asked for,
+// or -1 when they are too far apart to suggest
+func emailDistance(email string, a *authorStats) int {
+	email = strings.ToLower(email)
+	local := localPart(email)
+	authorEmail := strings.ToLower(a.Email)
+
+	// The same user name at another domain, or a name containing it, is a close match
+	// Very short user names would be found in too many names to mean anything
+	if local != "" && local == localPart(authorEmail) {
+		return 0
+	}
+	if len(local) >= 3 && strings.Contains(strings.ToLower(a.Name), local) {
+		return 0
+	}
+
+	d := editDistance(email, authorEmail)
+	if dl := editDistance(local, localPart(authorEmail)); dl < d {
+		d = dl
+	}
+	// Allow about one typo for every four characters, and at least two
+	limit := len(local) / 4
+	if limit < 2 {
+		limit = 2
+	}
+	if d > limit {
+		return -1
+	}
+	return d
+}
+
+// localPart returns the part of an email before the @
+func localPart(email string) string {
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		return email[:at]
+	}
+	return email
+}
+
+// editDistance is the Levenshtein distance: how many characters have to be
+// inserted, deleted or replaced to turn a into b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// printSuggestions says that no commit matched the emails and offers close authors instead
+func printSuggestions(w io.Writer, emails []string, suggestions []*authorStats) {
+	fmt.Fprintf(w, "No commits by %s in any repository, at any date.\n", strings.Join(emails, ", "))
+	if len(suggestions) > 0 {
+		fmt.Fprintln(w, "Did you mean:")
+		for _, a := range suggestions {
+			fmt.Fprintf(w, "  -email %s  (%s, %s commits)\n", a.Email, a.Name, formatCount(a.Commits))
+		}
+	}
+	fmt.Fprintln(w, "Run with -authors to see every author in the registered repositories.")
+	fmt.Fprintln(w)
+}
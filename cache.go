@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// commitCacheVersion is the version of the cache file format
+// A file with any other version is ignored and rebuilt
+const commitCacheVersion = 4
+
+// cachedFile is one file a commit changed compared to its first parent
+type cachedFile struct {
+	Path    string
+	Added   int
+	Deleted int
+}
+
+// cachedCommit is everything stats needs from a commit, so later runs don't read it again
+type cachedCommit struct {
+	Name    string // author name
+	Email   string // author email
+	When    time.Time
+	Parents []plumbing.Hash
+
+	// Files is filled in the first time stats needs it, which is only for commits
+	// that were counted; HasFiles tells "not worked out yet" from "changed nothing"
+	// Added and Deleted need the content of every file, so they are only worked
+	// out, and HasLines set, once a line -metric asks for them
+	Files    []cachedFile
+	HasFiles bool
+	HasLines bool
+
+	// PatchID is the commit's -patch-id (see history.go), filled in on first use
+	PatchID    string
+	HasPatchID bool
+}
+
+// commitCache is the on-disk index of one repository's history for one -refs value
+// Commits are stored by hash, and a commit never changes, so an entry stays right for
+// as long as the commit is reachable; Tips are the commits the last walk started from,
+// and Order every commit reachable from them, in the order they were found
+type commitCache struct {
+	Version int
+	Tips    []plumbing.Hash
+	Order   []plumbing.Hash
+	Commits map[plumbing.Hash]*cachedCommit
+
+	// Missing are parents the walk found no commit for, those of a shallow clone's
+	// oldest commits; once one turns up, after a fetch --unshallow or --deepen,
+	// the history below it has to be walked, so the next update starts over
+	Missing []plumbing.Hash
+
+	filePath string // where the cache is saved, empty for a cache that lives in memory only
+	dirty    bool   // something changed since the cache was loaded
+}
+
+// loadCommitCache returns the cache of a repository, empty if there is none yet
+// The file name is a hash of the repository path and the -refs value, since
+// different refs reach different commits; an empty dir gives a cache that is never saved
+func loadCommitCache(dir string, r foundRepo, refs string) *commitCache {
+	cache := &commitCache{
+		Version: commitCacheVersion,
+		Commits: make(map[plumbing.Hash]*cachedCommit),
+	}
+	if dir == "" {
+		return cache
+	}
+
+	key := sha1.Sum([]byte(r.path + "\x00" + refs))
+	cache.filePath = filepath.Join(dir, hex.EncodeToString(key[:])+".gob")
+
+	content, err := os.ReadFile(cache.filePath)
+	if err != nil {
+		return cache
+	}
+	var stored commitCache
+	// A cache we can't read is only a missed speed-up, so it is quietly rebuilt
+	if err := gob.NewDecoder(bytes.NewReader(content)).Decode(&stored); err != nil || stored.Version != commitCacheVersion {
+		return cache
+	}
+	cache.Tips = stored.Tips
+	cache.Order = stored.Order
+	cache.Missing = stored.Missing
+	if stored.Commits != nil {
+		cache.Commits = stored.Commits
+	}
+	return cache
+}
+
+// update brings the cache up to date with the history reachable from starts
+// Only commits added since the cached tips are walked: the walk stops at commits the
+// last run already reached, since everything below them is in Order already
+// It returns every reachable commit, the new ones first
+//
+// When one of the old tips is no longer reachable, a branch was force-pushed or history
+// was rewritten; the commits that went with it are then dropped from the cache
+func (c *commitCache) update(repo *git.Repository, starts []plumbing.Hash) ([]plumbing.Hash, error) {
+	// A clone that was deepened has history below commits the walk would stop at,
+	// so it is walked again in full; the commits already stored are still reused
+	found := make(map[plumbing.Hash]bool)
+	for _, hash := range c.Missing {
+		if _, err := repo.CommitObject(hash); err == nil {
+			found[hash] = true
+		}
+	}
+	rewalk := len(found) > 0
+	if rewalk {
+		c.Tips, c.Order, c.Missing = nil, nil, nil
+		// Their children were diffed against an empty tree, and are worked out again
+		for _, entry := range c.Commits {
+			if len(entry.Parents) > 0 && found[entry.Parents[0]] {
+				entry.Files, entry.HasFiles, entry.HasLines = nil, false, false
+				entry.PatchID, entry.HasPatchID = "", false
+			}
+		}
+	}
+
+	known := make(map[plumbing.Hash]bool, len(c.Order))
+	for _, hash := range c.Order {
+		known[hash] = true
+	}
+
+	// A depth-first walk, pushing parents in reverse so the first parent is visited first
+	var fresh []plumbing.Hash
+	reached := make(map[plumbing.Hash]bool)
+	stops := make(map[plumbing.Hash]bool) // known commits the walk ran into
+	stack := make([]plumbing.Hash, 0, len(starts))
+	isStart := make(map[plumbing.Hash]bool, len(starts))
+	for i := len(starts) - 1; i >= 0; i-- {
+		stack = append(stack, starts[i])
+		isStart[starts[i]] = true
+	}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if reached[hash] || stops[hash] {
+			continue
+		}
+		if known[hash] {
+			stops[hash] = true
+			continue
+		}
+
+		entry, ok := c.Commits[hash]
+		if !ok {
+			commit, err := repo.CommitObject(hash)
+			if err == plumbing.ErrObjectNotFound && !isStart[hash] {
+				// The parent of a shallow clone's oldest commit isn't there
+				// A missing tip is a broken repository though, and reported
+				if !slices.Contains(c.Missing, hash) {
+					c.Missing = append(c.Missing, hash)
+					c.dirty = true
+				}
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			entry = &cachedCommit{
+				Name:    commit.Author.Name,
+				Email:   commit.Author.Email,
+				When:    commit.Author.When,
+				Parents: commit.ParentHashes,
+			}
+			c.Commits[hash] = entry
+		}
+
+		reached[hash] = true
+		fresh = append(fresh, hash)
+		for i := len(entry.Parents) - 1; i >= 0; i-- {
+			stack = append(stack, entry.Parents[i])
+		}
+	}
+
+	// An old tip that is still a tip, or that the walk ran into, keeps everything below
+	// it reachable; only when one isn't is the cached graph followed to find what's left
+	old := c.Order
+	for _, tip := range c.Tips {
+		if !isStart[tip] && !stops[tip] {
+			old = c.reachable(stops, old)
+			break
+		}
+	}
+
+	if rewalk {
+		// Commits only reachable from old tips were never run into, so drop them here
+		for hash := range c.Commits {
+			if !reached[hash] {
+				delete(c.Commits, hash)
+			}
+		}
+	}
+
+	order := append(fresh, old...)
+	if rewalk || len(fresh) > 0 || len(old) != len(c.Order) || !sameHashes(c.Tips, starts) {
+		c.Tips = starts
+		c.Order = order
+		c.dirty = true
+	}
+	return order, nil
+}
+
+// reachable keeps the commits of order that can be reached from starts through the
+// parents stored in the cache, and drops the others from the cache
+// It reads nothing from the repository
+func (c *commitCache) reachable(starts map[plumbing.Hash]bool, order []plumbing.Hash) []plumbing.Hash {
+	keep := make(map[plumbing.Hash]bool)
+	var stack []plumbing.Hash
+	for hash := range starts {
+		stack = append(stack, hash)
+	}
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		entry, ok := c.Commits[hash]
+		if !ok || keep[hash] {
+			continue
+		}
+		keep[hash] = true
+		stack = append(stack, entry.Parents...)
+	}
+
+	var kept []plumbing.Hash
+	for _, hash := range order {
+		if keep[hash] {
+			kept = append(kept, hash)
+		} else {
+			delete(c.Commits, hash)
+		}
+	}
+	return kept
+}
+
+// files returns the files a commit changed, working them out on first use
+// The line counts are only filled in when lines is true: they need a patch, which
+// reads the content of every changed file, while the paths only need the trees
+func (c *commitCache) files(repo *git.Repository, hash plumbing.Hash, lines bool) ([]cachedFile, error) {
+	entry := c.Commits[hash]
+	if entry.HasFiles && (entry.HasLines || !lines) {
+		return entry.Files, nil
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	// firstParentChanges is defined in history.go
+	changes, err := firstParentChanges(commit)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]cachedFile, 0, len(changes))
+	for _, change := range changes {
+		// A submodule pointer moving is a commit id in the tree, not a changed file
+		if isSubmoduleChange(change) {
+			continue
+		}
+		file := cachedFile{Path: changeName(change)}
+		if lines {
+			patch, err := change.Patch()
+			if err != nil {
+				return nil, err
+			}
+			for _, fp := range patch.FilePatches() {
+				for _, chunk := range fp.Chunks() {
+					switch chunk.Type() {
+					case diff.Add:
+						file.Added += countLines(chunk.Content())
+					case diff.Delete:
+						file.Deleted += countLines(chunk.Content())
+					}
+				}
+			}
+		}
+		files = append(files, file)
+	}
+	entry.Files = files
+	entry.HasFiles = true
+	entry.HasLines = lines
+	c.dirty = true
+	return entry.Files, nil
+}
+
+// patchID returns the -patch-id of a commit, working it out on first use
+func (c *commitCache) patchID(repo *git.Repository, hash plumbing.Hash) (string, error) {
+	entry := c.Commits[hash]
+	if entry.HasPatchID {
+		return entry.PatchID, nil
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return "", err
+	}
+	// patchID is defined in history.go
+	id, err := patchID(commit)
+	if err != nil {
+		return "", err
+	}
+	entry.PatchID = id
+	entry.HasPatchID = true
+	c.dirty = true
+	return id, nil
+}
+
+// save writes the cache back if anything changed
+func (c *commitCache) save() error {
+	if c.filePath == "" || !c.dirty {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.filePath), 0755); err != nil {
+		return err
+	}
+	// writeFileAtomic is defined in registry.go; two runs saving at once
+	// each write a whole file, and the last one to finish wins
+	return writeFileAtomic(c.filePath, buf.Bytes())
+}
+
+// changeName returns the path of a changed file
+// A deleted file only has a name on the From side
+func changeName(change *object.Change) string {
+	if change.To.Name != "" {
+		return change.To.Name
+	}
+	return change.From.Name
+}
+
+// isSubmoduleChange reports whether a change is to a submodule pointer (a gitlink entry)
+// rather than a file; the side changeName takes the path from is the one looked at
+func isSubmoduleChange(change *object.Change) bool {
+	if change.To.Name != "" {
+		return change.To.TreeEntry.Mode == filemode.Submodule
+	}
+	return change.From.TreeEntry.Mode == filemode.Submodule
+}
+
+// countLines counts the lines of a diff chunk, including a last one with no newline
+func countLines(content string) int {
+	if content == "" {
+		return 0
+	}
+	n := strings.Count(content, "\n")
+	if !strings.HasSuffix(content, "\n") {
+		n++
+	}
+	return n
+}
+
+// sameHashes reports whether two lists hold the same hashes in the same order
+func sameHashes(a, b []plumbing.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
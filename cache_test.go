@@ -0,0 +1,290 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// testRepo is an in-memory repository with a worktree to commit from
+type testRepo struct {
+	t    *testing.T
+	repo *git.Repository
+	wt   *git.Worktree
+	when time.Time
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, repo: repo, wt: wt, when: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// signature returns an author one hour later than the last one, so commits sort by date
+func (r *testRepo) signature() *object.Signature {
+	r.when = r.when.Add(time.Hour)
+	return &object.Signature{Name: "Test", Email: "test@example.com", When: r.when}
+}
+
+// commit makes an empty commit on top of HEAD
+func (r *testRepo) commit(msg string) plumbing.Hash {
+	r.t.Helper()
+	hash, err := r.wt.Commit(msg, &git.CommitOptions{Author: r.signature(), AllowEmptyCommits: true})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return hash
+}
+
+// writeFile writes a file in the worktree and stages it for the next commit
+func (r *testRepo) writeFile(name, content string) {
+	r.t.Helper()
+	f, err := r.wt.Filesystem.Create(name)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		r.t.Fatal(err)
+	}
+	if _, err := r.wt.Add(name); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// branch points a new branch at hash and checks it out
+func (r *testRepo) branch(name string, hash plumbing.Hash) {
+	r.t.Helper()
+	err := r.wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(name), Hash: hash, Create: true})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// checkout switches to an existing branch
+func (r *testRepo) checkout(name string) {
+	r.t.Helper()
+	if err := r.wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(name)}); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// update brings cache up to date with the refs, like a stats run with the cache
+func (r *testRepo) update(cache *commitCache, refs string) []plumbing.Hash {
+	r.t.Helper()
+	starts, err := startCommits(r.repo, refs)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	order, err := cache.update(r.repo, starts)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return order
+}
+
+// checkAgainstFullWalk compares a cached walk with what -no-cache finds, and checks
+// the cache holds exactly the commits that walk reached
+func (r *testRepo) checkAgainstFullWalk(cache *commitCache, order []plumbing.Hash, refs string) {
+	r.t.Helper()
+	want := r.update(loadCommitCache("", foundRepo{}, refs), refs)
+	if !sameHashes(order, want) {
+		r.t.Fatalf("cached walk found %v, -no-cache finds %v", order, want)
+	}
+	if len(cache.Commits) != len(want) {
+		r.t.Fatalf("cache holds %d commits, %d are reachable", len(cache.Commits), len(want))
+	}
+}
+
+// newSavedCache returns a cache that goes through the disk between runs
+func newSavedCache(t *testing.T, refs string) func() *commitCache {
+	dir := t.TempDir()
+	r := foundRepo{path: "/test/repo", kind: kindNormal}
+	var last *commitCache
+	return func() *commitCache {
+		if last != nil {
+			if err := last.save(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		last = loadCommitCache(dir, r, refs)
+		return last
+	}
+}
+
+func TestCommitCacheFastForward(t *testing.T) {
+	r := newTestRepo(t)
+	run := newSavedCache(t, refsHead)
+	r.commit("one")
+	r.commit("two")
+
+	cache := run()
+	r.checkAgainstFullWalk(cache, r.update(cache, refsHead), refsHead)
+
+	r.commit("three")
+	r.commit("four")
+	cache = run()
+	order := r.update(cache, refsHead)
+	r.checkAgainstFullWalk(cache, order, refsHead)
+	if len(order) != 4 {
+		t.Fatalf("got %d commits, want 4", len(order))
+	}
+}
+
+func TestCommitCacheResetWithoutNewCommit(t *testing.T) {
+	r := newTestRepo(t)
+	run := newSavedCache(t, refsHead)
+	r.commit("one")
+	two := r.commit("two")
+	three := r.commit("three")
+
+	cache := run()
+	r.checkAgainstFullWalk(cache, r.update(cache, refsHead), refsHead)
+
+	// git reset --hard HEAD~1
+	if err := r.wt.Reset(&git.ResetOptions{Commit: two, Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	cache = run()
+	order := r.update(cache, refsHead)
+	r.checkAgainstFullWalk(cache, order, refsHead)
+	if _, ok := cache.Commits[three]; ok {
+		t.Fatal("the commit reset away is still in the cache")
+	}
+	if !cache.dirty {
+		t.Fatal("the cache isn't saved after dropping a commit")
+	}
+}
+
+func TestCommitCacheDeletedBranch(t *testing.T) {
+	r := newTestRepo(t)
+	run := newSavedCache(t, refsLocal)
+	r.commit("one")
+	two := r.commit("two")
+	r.branch("feature", two)
+	three := r.commit("three")
+	four := r.commit("four")
+	r.checkout("master")
+
+	cache := run()
+	order := r.update(cache, refsLocal)
+	r.checkAgainstFullWalk(cache, order, refsLocal)
+	if len(order) != 4 {
+		t.Fatalf("got %d commits, want 4", len(order))
+	}
+
+	if err := r.repo.Storer.RemoveReference(plumbing.NewBranchReferenceName("feature")); err != nil {
+		t.Fatal(err)
+	}
+	cache = run()
+	order = r.update(cache, refsLocal)
+	r.checkAgainstFullWalk(cache, order, refsLocal)
+	for _, gone := range []plumbing.Hash{three, four} {
+		if _, ok := cache.Commits[gone]; ok {
+			t.Fatalf("commit %s of the deleted branch is still in the cache", gone)
+		}
+	}
+}
+
+func TestCommitCacheShallowParent(t *testing.T) {
+	r := newTestRepo(t)
+	run := newSavedCache(t, refsHead)
+	r.writeFile("main.go", "package main\n")
+	first := r.commit("one")
+
+	// A commit whose parent isn't in the repository, like the oldest commit of a shallow clone
+	// The parent is only encoded for now; storing it later is what fetch --unshallow does
+	firstCommit, err := r.repo.CommitObject(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := &object.Commit{
+		Author:       *r.signature(),
+		Committer:    *r.signature(),
+		Message:      "parent",
+		TreeHash:     firstCommit.TreeHash,
+		ParentHashes: []plumbing.Hash{first},
+	}
+	parentObj := &plumbing.MemoryObject{}
+	if err := parent.Encode(parentObj); err != nil {
+		t.Fatal(err)
+	}
+	shallow := &object.Commit{
+		Author:       *r.signature(),
+		Committer:    *r.signature(),
+		Message:      "shallow",
+		TreeHash:     firstCommit.TreeHash,
+		ParentHashes: []plumbing.Hash{parentObj.Hash()},
+	}
+	obj := r.repo.Storer.NewEncodedObject()
+	if err := shallow.Encode(obj); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := r.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("master"), hash)); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := run()
+	order := r.update(cache, refsHead)
+	r.checkAgainstFullWalk(cache, order, refsHead)
+	if len(order) != 1 {
+		t.Fatalf("got %d commits, want only the shallow one", len(order))
+	}
+
+	// Without its parent, the commit is diffed against an empty tree like a root commit
+	files, err := cache.files(r.repo, hash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "main.go" || files[0].Added != 1 {
+		t.Fatalf("got changed files %+v, want main.go with 1 line added", files)
+	}
+	if _, err := cache.patchID(r.repo, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	r.commit("two")
+	cache = run()
+	order = r.update(cache, refsHead)
+	r.checkAgainstFullWalk(cache, order, refsHead)
+	if len(order) != 2 {
+		t.Fatalf("got %d commits, want 2", len(order))
+	}
+
+	// git fetch --unshallow brings in the parent and everything below it
+	if _, err := r.repo.Storer.SetEncodedObject(parentObj); err != nil {
+		t.Fatal(err)
+	}
+	cache = run()
+	order = r.update(cache, refsHead)
+	r.checkAgainstFullWalk(cache, order, refsHead)
+	if len(order) != 4 {
+		t.Fatalf("got %d commits after unshallowing, want 4", len(order))
+	}
+	// The parent has the same tree, so the commit no longer adds anything
+	files, err = cache.files(r.repo, hash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("got changed files %+v after unshallowing, want none", files)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// registryEnvVar lets scripts and containers point at a registry without passing -registry
+const registryEnvVar = "GITCONTRIB_REGISTRY"
+
+// legacyDotFile is where the registry lived before the XDG layout, relative to the home directory
+const legacyDotFile = ".gogitlocalstats"
+
+// configPaths says where the program keeps its files
+type configPaths struct {
+	dir      string // configuration directory, e.g. ~/.config/gitcontrib
+	registry string // the repository registry
+	legacy   bool   // registry is the old ~/.gogitlocalstats dotfile
+}
+
+// ignoreFile is the gitignore-style file read by -add
+// Users of the old dotfile keep ~/.gogitlocalstatsignore next to it
+func (p configPaths) ignoreFile() string {
+	if p.legacy {
+		return p.registry + "ignore"
+	}
+	return filepath.Join(p.dir, "ignore")
+}
+
+// commitCacheDir is where stats keeps what it learned about each repository's history:
+// $XDG_CACHE_HOME/gitcontrib, ~/.cache/gitcontrib when XDG_CACHE_HOME isn't set
+// It is kept apart from the registry, which may be on a read-only mount
+// Deleting it is always safe; the next run rebuilds it. Without a home directory
+// it is empty, and the cache only lives as long as the run
+func commitCacheDir() string {
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "gitcontrib")
+	}
+	if home, err := homeDir(); err == nil {
+		return filepath.Join(home, ".cache", "gitcontrib")
+	}
+	return ""
+}
+
+// homeDir finds the user's home directory
+// $HOME is checked first because user.Current() fails in some minimal containers
+// where the user id has no entry in /etc/passwd
+func homeDir() (string, error) {
+	if home, err := os.UserHomeDir(); err == nil {
+		return home, nil
+	}
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return usr.HomeDir, nil
+}
+
+// fileExists reports whether something exists at path
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// resolveConfigPaths works out where the registry and configuration live
+// The registry is taken from, in order:
+//  1. the -registry flag
+//  2. the GITCONTRIB_REGISTRY environment variable
+//  3. registry.json in the -config directory, or in $XDG_CONFIG_HOME/gitcontrib
+//     (~/.config/gitcontrib when XDG_CONFIG_HOME isn't set)
+//  4. the legacy ~/.gogitlocalstats dotfile
+//
+// Step 3 only wins over step 4 without -config when its registry already exists or
+// there is no legacy dotfile, so existing users keep their registry until they move it
+func resolveConfigPaths(configFlag string, registryFlag string) (configPaths, error) {
+	home, homeErr := homeDir()
+
+	dir := configFlag
+	if dir == "" {
+		if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+			dir = filepath.Join(xdg, "gitcontrib")
+		} else if homeErr == nil {
+			dir = filepath.Join(home, ".config", "gitcontrib")
+		}
+	}
+
+	paths := configPaths{dir: dir}
+	switch {
+	case registryFlag != "":
+		paths.registry = registryFlag
+	case os.Getenv(registryEnvVar) != "":
+		paths.registry = os.Getenv(registryEnvVar)
+	case configFlag != "":
+		paths.registry = filepath.Join(dir, "registry.json")
+	default:
+		var legacy string
+		if homeErr == nil {
+			legacy = filepath.Join(home, legacyDotFile)
+		}
+		xdgRegistry := ""
+		if dir != "" {
+			xdgRegistry = filepath.Join(dir, "registry.json")
+		}
+
+		switch {
+		case xdgRegistry != "" && (fileExists(xdgRegistry) || legacy == "" || !fileExists(legacy)):
+			paths.registry = xdgRegistry
+		case legacy != "":
+			paths.registry = legacy
+			paths.legacy = true
+		default:
+			return configPaths{}, errors.New("can't find a home directory to keep the registry in; use -registry, -config or " + registryEnvVar)
+		}
+	}
+
+	// With only -registry or the environment variable to go on, keep the other
+	// configuration files next to the registry
+	if paths.dir == "" {
+		paths.dir = filepath.Dir(paths.registry)
+	}
+
+	// Resolve relative flags now, so messages show where the files really are
+	paths.registry = absPath(paths.registry)
+	paths.dir = absPath(paths.dir)
+	return paths, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultRangeDays is how far back stats looks when -since isn't given, about six months
+const defaultRangeDays = 183
+
+// civilDate is a day on the calendar, e.g. 2025-03-14, with no time or time zone
+// Commits are bucketed by civilDate in the display time zone, so counting days
+// never depends on how long a day was: a DST change can't move a commit
+type civilDate struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+// dateOf returns the calendar day t falls on, in t's own location
+// Convert with t.In(loc) first to get the day in another time zone
+func dateOf(t time.Time) civilDate {
+	year, month, day := t.Date()
+	return civilDate{year, month, day}
+}
+
+// time returns midnight UTC on the date
+// UTC has no DST, so arithmetic on these values always moves in whole days
+func (d civilDate) time() time.Time {
+	return time.Date(d.year, d.month, d.day, 0, 0, 0, 0, time.UTC)
+}
+
+// addDays returns the date n days later, or earlier for a negative n
+func (d civilDate) addDays(n int) civilDate {
+	return dateOf(d.time().AddDate(0, 0, n))
+}
+
+// weekday returns the day of the week, time.Sunday to time.Saturday
+func (d civilDate) weekday() time.Weekday {
+	return d.time().Weekday()
+}
+
+// before reports whether d is an earlier day than other
+func (d civilDate) before(other civilDate) bool {
+	return d.time().Before(other.time())
+}
+
+// after reports whether d is a later day than other
+func (d civilDate) after(other civilDate) bool {
+	return d.time().After(other.time())
+}
+
+// daysUntil counts the days from d to other, negative when other is earlier
+func (d civilDate) daysUntil(other civilDate) int {
+	return int(other.time().Sub(d.time()).Hours() / 24)
+}
+
+// String formats the date as 2025-03-14
+func (d civilDate) String() string {
+	return d.time().Format("2006-01-02")
+}
+
+// dateRange is the span of days a report covers, both ends included
+type dateRange struct {
+	start civilDate
+	end   civilDate
+}
+
+// days returns how many days the range covers, counting both ends
+func (r dateRange) days() int {
+	return r.start.daysUntil(r.end) + 1
+}
+
+// contains reports whether a day falls inside the range
+func (r dateRange) contains(d civilDate) bool {
+	return !d.before(r.start) && !d.after(r.end)
+}
+
+// String formats the range for report headers, e.g. "2025-01-01 to 2025-03-31"
+func (r dateRange) String() string {
+	return r.start.String() + " to " + r.end.String()
+}
+
+// yearRange returns January 1st to December 31st of a year
+func yearRange(year int) dateRange {
+	return dateRange{
+		start: civilDate{year, time.January, 1},
+		end:   civilDate{year, time.December, 31},
+	}
+}
+
+// parseYears understands the -years value: either a number of years counted back from
+// this one, e.g. 3 for this year and the two before it, or a span like 2021-2025
+// The years come back newest first, the order they are printed in
+func parseYears(value string, thisYear int) ([]int, error) {
+	first, last := 0, 0
+	if from, to, ok := strings.Cut(value, "-"); ok {
+		var err1, err2 error
+		first, err1 = strconv.Atoi(strings.TrimSpace(from))
+		last, err2 = strconv.Atoi(strings.TrimSpace(to))
+		if err1 != nil || err2 != nil || first > last {
+			return nil, fmt.Errorf("-years: can't understand %q, use e.g. 3 or 2021-2025", value)
+		}
+	} else {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("-years: can't understand %q, use e.g. 3 or 2021-2025", value)
+		}
+		first, last = thisYear-n+1, thisYear
+	}
+
+	var years []int
+	for year := last; year >= first; year-- {
+		years = append(years, year)
+	}
+	return years, nil
+}
+
+// dateSpec is what a single -since or -until value means
+// Named periods like "last-quarter" cover several days, so they have both a first and
+// a last day; a plain date or a relative value like "3m" is a single day
+type dateSpec struct {
+	first  civilDate
+	last   civilDate
+	period bool // a named period, which gives a whole range on its own
+}
+
+// parseDateRange turns the -since and -until values into a range
+// Without -until the range ends today, and without -since it starts defaultRangeDays earlier
+// A named period given to -since alone, e.g. -since last-quarter, covers exactly that period
+// today is the current date in the display time zone
+func parseDateRange(since string, until string, today civilDate) (dateRange, error) {
+	rng := dateRange{end: today}
+
+	var sinceSpec *dateSpec
+	if since != "" {
+		spec, err := parseDateSpec(since, today)
+		if err != nil {
+			return dateRange{}, fmt.Errorf("-since: %w", err)
+		}
+		sinceSpec = &spec
+	}
+
+	switch {
+	case until != "":
+		spec, err := parseDateSpec(until, today)
+		if err != nil {
+			return dateRange{}, fmt.Errorf("-until: %w", err)
+		}
+		rng.end = spec.last
+	case sinceSpec != nil && sinceSpec.period:
+		rng.end = sinceSpec.last
+	}
+
+	if sinceSpec != nil {
+		rng.start = sinceSpec.first
+	} else {
+		rng.start = rng.end.addDays(-(defaultRangeDays - 1))
+	}
+
+	if rng.start.after(rng.end) {
+		return dateRange{}, fmt.Errorf("-since %s is after -until %s", rng.start, rng.end)
+	}
+	return rng, nil
+}
+
+// parseDateSpec understands:
+//   - absolute dates: 2025-03-14, 2025-03 (the whole month), 2025 (the whole year)
+//   - relative values counted back from today: 30d, 6w, 3m, 1y
+//   - named days and periods: today, yesterday, this-week, last-week, this-month,
+//     last-month, this-quarter, last-quarter, this-year, last-year
+//
+// The arithmetic is done on midnight UTC (see civilDate.time), where every day is 24 hours
+func parseDateSpec(value string, todayDate civilDate) (dateSpec, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	today := todayDate.time()
+	loc := time.UTC
+
+	// Absolute dates, from the most to the least precise
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		return dateSpec{first: dateOf(t), last: dateOf(t)}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01", value, loc); err == nil {
+		return dateSpec{first: dateOf(t), last: dateOf(t.AddDate(0, 1, -1))}, nil
+	}
+	if len(value) == 4 {
+		if t, err := time.ParseInLocation("2006", value, loc); err == nil {
+			return dateSpec{first: dateOf(t), last: dateOf(t.AddDate(1, 0, -1))}, nil
+		}
+	}
+
+	// Relative values: a number followed by d, w, m or y
+	if len(value) >= 2 {
+		if n, err := strconv.Atoi(value[:len(value)-1]); err == nil && n >= 0 {
+			var t time.Time
+			switch value[len(value)-1] {
+			case 'd':
+				t = today.AddDate(0, 0, -n)
+			case 'w':
+				t = today.AddDate(0, 0, -7*n)
+			case 'm':
+				t = today.AddDate(0, -n, 0)
+			case 'y':
+				t = today.AddDate(-n, 0, 0)
+			default:
+				return dateSpec{}, fmt.Errorf("unknown unit in %q, use d, w, m or y", value)
+			}
+			return dateSpec{first: dateOf(t), last: dateOf(t)}, nil
+		}
+	}
+
+	// Named periods, all relative to today
+	year, month, _ := today.Date()
+	startOfMonth := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	startOfQuarter := time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, loc)
+	startOfYear := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	// Weeks start on Sunday, like the columns of the heatmap
+	startOfWeek := today.AddDate(0, 0, -int(today.Weekday()))
+
+	var first, last time.Time
+	switch value {
+	case "today":
+		first, last = today, today
+	case "yesterday":
+		first = today.AddDate(0, 0, -1)
+		last = first
+	case "this-week":
+		first, last = startOfWeek, today
+	case "last-week":
+		first, last = startOfWeek.AddDate(0, 0, -7), startOfWeek.AddDate(0, 0, -1)
+	case "this-month":
+		first, last = startOfMonth, today
+	case "last-month":
+		first, last = startOfMonth.AddDate(0, -1, 0), startOfMonth.AddDate(0, 0, -1)
+	case "this-quarter":
+		first, last = startOfQuarter, today
+	case "last-quarter":
+		first, last = startOfQuarter.AddDate(0, -3, 0), startOfQuarter.AddDate(0, 0, -1)
+	case "this-year":
+		first, last = startOfYear, today
+	case "last-year":
+		first, last = startOfYear.AddDate(-1, 0, 0), startOfYear.AddDate(0, 0, -1)
+	default:
+		return dateSpec{}, fmt.Errorf("can't understand date %q, use e.g. 2025-03-14, 2025-03, 3m, 1y or last-quarter", value)
+	}
+	return dateSpec{first: dateOf(first), last: dateOf(last), period: true}, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileIdentity is not available on this platform
+// Symlink loop detection and -one-file-system are skipped when it returns false
+func fileIdentity(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
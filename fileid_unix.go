@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns the device and inode of a file
+// Two paths with the same identity are the same directory, even when reached through a symlink
+func fileIdentity(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	// The field types differ between platforms, e.g. Dev is int32 on macOS
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
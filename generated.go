@@ -0,0 +1,170 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// The .gitattributes markers GitHub's linguist uses to leave files out of language stats
+const (
+	attrGenerated = "linguist-generated"
+	attrVendored  = "linguist-vendored"
+)
+
+// generatedFilenames are files no one writes by hand, mostly dependency lockfiles
+var generatedFilenames = map[string]bool{
+	"package-lock.json":   true,
+	"npm-shrinkwrap.json": true,
+	"yarn.lock":           true,
+	"pnpm-lock.yaml":      true,
+	"bun.lockb":           true,
+	"Cargo.lock":          true,
+	"go.sum":              true,
+	"composer.lock":       true,
+	"Gemfile.lock":        true,
+	"Podfile.lock":        true,
+	"poetry.lock":         true,
+	"Pipfile.lock":        true,
+	"uv.lock":             true,
+	"flake.lock":          true,
+	"mix.lock":            true,
+	"pubspec.lock":        true,
+}
+
+// generatedSuffixes are the endings of minified bundles, source maps and generated code
+var generatedSuffixes = []string{
+	".min.js",
+	".min.css",
+	".map",
+	".pb.go",
+	".pb.gw.go",
+	"_pb2.py",
+	"_pb2_grpc.py",
+	".pb.cc",
+	".pb.h",
+	"_generated.go",
+	".generated.ts",
+}
+
+// vendoredDirs are directories of third-party code, at any depth
+var vendoredDirs = map[string]bool{
+	"vendor":           true,
+	"node_modules":     true,
+	"third_party":      true,
+	"bower_components": true,
+}
+
+// generatedRules decides which changed files the file type table leaves out
+// A nil *generatedRules leaves nothing out, which is what -include-generated gives
+type generatedRules struct {
+	attributes gitattributes.Matcher // the repository's .gitattributes, nil if it has none
+}
+
+// loadGeneratedRules reads the .gitattributes files committed at HEAD, in every directory
+// Only tree entries are listed, so this doesn't read the content of any other file
+func loadGeneratedRules(repo *git.Repository) *generatedRules {
+	rules := &generatedRules{}
+
+	head, err := repo.Head()
+	if err != nil {
+		return rules
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return rules
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return rules
+	}
+
+	var patterns []gitattributes.MatchAttribute
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err != nil {
+			break
+		}
+		if path.Base(name) != ".gitattributes" || entry.Mode != filemode.Regular {
+			continue
+		}
+		file, err := tree.File(name)
+		if err != nil {
+			continue
+		}
+		content, err := file.Contents()
+		if err != nil {
+			continue
+		}
+
+		// Patterns in a subdirectory's .gitattributes only apply below it
+		var domain []string
+		if dir := path.Dir(name); dir != "." {
+			domain = strings.Split(dir, "/")
+		}
+		// Macros may only be defined at the top level, as in git
+		found, err := gitattributes.ReadAttributes(strings.NewReader(content), domain, domain == nil)
+		if err != nil {
+			continue
+		}
+		patterns = append(patterns, found...)
+	}
+
+	if len(patterns) > 0 {
+		rules.attributes = gitattributes.NewMatcher(patterns)
+	}
+	return rules
+}
+
+// excluded reports whether a file is generated or vendored
+// .gitattributes wins either way: "linguist-generated" marks a file, and
+// "-linguist-generated" brings back one the built-in heuristics would leave out
+func (r *generatedRules) excluded(name string) bool {
+	if r == nil {
+		return false
+	}
+
+	if r.attributes != nil {
+		results, _ := r.attributes.Match(strings.Split(name, "/"), []string{attrGenerated, attrVendored})
+		unmarked := false
+		for _, attr := range results {
+			switch {
+			case attr.IsSet() || (attr.IsValueSet() && attr.Value() == "true"):
+				return true
+			case attr.IsUnset() || (attr.IsValueSet() && attr.Value() == "false"):
+				unmarked = true
+			}
+		}
+		if unmarked {
+			return false
+		}
+	}
+
+	return isGeneratedPath(name)
+}
+
+// isGeneratedPath applies the built-in heuristics for generated and vendored files
+func isGeneratedPath(name string) bool {
+	base := path.Base(name)
+	if generatedFilenames[base] {
+		return true
+	}
+	lower := strings.ToLower(base)
+	for _, suffix := range generatedSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	for _, dir := range strings.Split(path.Dir(name), "/") {
+		if vendoredDirs[dir] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// The -refs values with a fixed meaning; anything else is a glob pattern over ref names
+const (
+	refsHead  = "head"  // only the commit checked out, the old behaviour
+	refsLocal = "local" // every local branch, plus a detached HEAD
+	refsAll   = "all"   // every branch, remote-tracking branch and tag
+)
+
+// checkRefsOption rejects -refs values that are neither a mode nor a valid glob
+func checkRefsOption(refs string) error {
+	switch refs {
+	case refsHead, refsLocal, refsAll:
+		return nil
+	}
+	if _, err := path.Match(refs, ""); err != nil {
+		return fmt.Errorf("-refs: bad pattern %q: %w", refs, err)
+	}
+	return nil
+}
+
+// startCommits returns the commits history is walked from for a -refs value
+// A glob such as "feature/*" or "refs/remotes/origin/*" is matched against both
+// the full ref name and its short form, so either way of writing it works
+func startCommits(repo *git.Repository, refs string) ([]plumbing.Hash, error) {
+	// HEAD is a starting point in every mode except a glob, where it
+	// counts only if the pattern asks for it
+	var starts []plumbing.Hash
+	if refs == refsHead || refs == refsLocal || refs == refsAll {
+		// A repository with no commits yet has a HEAD pointing at a branch that
+		// doesn't exist; it simply has nothing to count
+		head, err := repo.Head()
+		switch {
+		case err == plumbing.ErrReferenceNotFound:
+		case err != nil:
+			return nil, err
+		default:
+			starts = append(starts, head.Hash())
+		}
+		if refs == refsHead {
+			return starts, nil
+		}
+	}
+
+	iter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		// Symbolic refs like origin/HEAD point at a branch that is walked anyway
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		name := ref.Name()
+		switch refs {
+		case refsLocal:
+			if !name.IsBranch() {
+				return nil
+			}
+		case refsAll:
+			if !name.IsBranch() && !name.IsRemote() && !name.IsTag() {
+				return nil
+			}
+		default:
+			full, _ := path.Match(refs, name.String())
+			short, _ := path.Match(refs, name.Short())
+			if !full && !short {
+				return nil
+			}
+		}
+
+		if hash, ok := peelToCommit(repo, ref.Hash()); ok {
+			starts = append(starts, hash)
+		}
+		return nil
+	})
+	return starts, err
+}
+
+// peelToCommit follows annotated tags down to the commit they point at
+// Tags of trees or blobs have no history and are skipped
+func peelToCommit(repo *git.Repository, hash plumbing.Hash) (plumbing.Hash, bool) {
+	if _, err := repo.CommitObject(hash); err == nil {
+		return hash, true
+	}
+	tag, err := repo.TagObject(hash)
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	commit, err := tag.Commit()
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	return commit.Hash, true
+}
+
+// commitDedup remembers what has been counted, across every ref and every repository
+// Clones and worktrees of one project share their history, so a commit hash
+// counted once is never counted again
+type commitDedup struct {
+	hashes  map[plumbing.Hash]bool
+	patches map[string]*matchedCommit // counted commit of each patch-id, nil unless -patch-id is set
+}
+
+// newCommitDedup creates an empty dedup set, tracking patch-ids when byPatch is true
+func newCommitDedup(byPatch bool) *commitDedup {
+	d := &commitDedup{hashes: make(map[plumbing.Hash]bool)}
+	if byPatch {
+		d.patches = make(map[string]*matchedCommit)
+	}
+	return d
+}
+
+// countPatch reports whether a commit is to be counted, and records it
+// It is always true without -patch-id, or for a commit with no patch-id. With it,
+// only the earliest copy of a change counts, the lowest hash on the same day, so
+// the outcome doesn't hang on walk order; replaced is the copy counted until now,
+// which the caller takes back out of its totals
+func (d *commitDedup) countPatch(c *matchedCommit) (counted bool, replaced *matchedCommit) {
+	if d.patches == nil || c.patchID == "" {
+		return true, nil
+	}
+	prev := d.patches[c.patchID]
+	if prev != nil {
+		if !c.day.before(prev.day) && (prev.day.before(c.day) || prev.hash.String() < c.hash.String()) {
+			return false, nil
+		}
+	}
+	d.patches[c.patchID] = c
+	return true, prev
+}
+
+// patchID hashes the change a commit makes, in the spirit of git patch-id:
+// file names and added or removed lines with whitespace removed, but not line numbers,
+// so the same change applied at another point in history gets the same id
+// Merges and commits changing nothing have no patch-id and return ""
+func patchID(c *object.Commit) (string, error) {
+	if c.NumParents() > 1 {
+		return "", nil
+	}
+
+	changes, err := firstParentChanges(c)
+	if err != nil {
+		return "", err
+	}
+	if len(changes) == 0 {
+		return "", nil
+	}
+	patch, err := changes.Patch()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha1.New()
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		for _, f := range []diff.File{from, to} {
+			if f != nil {
+				h.Write([]byte(f.Path()))
+			}
+			h.Write([]byte{0})
+		}
+		for _, chunk := range fp.Chunks() {
+			var sign byte
+			switch chunk.Type() {
+			case diff.Add:
+				sign = '+'
+			case diff.Delete:
+				sign = '-'
+			default:
+				continue
+			}
+			for _, line := range strings.Split(chunk.Content(), "\n") {
+				h.Write([]byte{sign})
+				h.Write([]byte(strings.Join(strings.Fields(line), "")))
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// firstParentChanges returns the files a commit changed compared to its first parent
+// A root commit is compared to an empty tree, so everything in it counts as added
+// So is the oldest commit of a shallow clone, whose parent isn't in the repository
+func firstParentChanges(c *object.Commit) (object.Changes, error) {
+	var parentTree *object.Tree
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		switch {
+		case err == plumbing.ErrObjectNotFound:
+		case err != nil:
+			return nil, err
+		default:
+			if parentTree, err = parent.Tree(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return object.DiffTree(parentTree, tree)
+}
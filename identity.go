@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+)
+
+// identitiesFileName is the global alias file in the config directory
+// It uses the .mailmap format, so the same lines work in both places
+const identitiesFileName = "identities"
+
+// mailmapEntry is one line of a .mailmap file
+// Empty fields mean "not given": a line without a proper email only fixes the name
+type mailmapEntry struct {
+	properName  string
+	properEmail string
+	commitName  string
+	commitEmail string // stored in lower case, git compares emails case-insensitively
+}
+
+// mailmap maps the names and emails recorded in commits to canonical ones
+// A nil *mailmap is valid and maps everything to itself
+type mailmap struct {
+	entries []mailmapEntry
+}
+
+// parseMailmap reads .mailmap content; the accepted line forms are
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+//
+// Lines that don't fit are ignored, like git does
+func parseMailmap(content string) *mailmap {
+	m := &mailmap{}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		// '#' starts a comment, unless it is inside an email address
+		if i := strings.IndexByte(line, '#'); i >= 0 && !strings.Contains(line[:i], "<") {
+			line = line[:i]
+		}
+
+		var names, emails []string
+		rest := line
+		for {
+			lt := strings.IndexByte(rest, '<')
+			if lt < 0 {
+				break
+			}
+			gt := strings.IndexByte(rest[lt:], '>')
+			if gt < 0 {
+				break
+			}
+			names = append(names, strings.TrimSpace(rest[:lt]))
+			emails = append(emails, strings.TrimSpace(rest[lt+1:lt+gt]))
+			rest = rest[lt+gt+1:]
+		}
+
+		var e mailmapEntry
+		switch len(emails) {
+		case 1:
+			// Proper Name <commit@email>
+			e = mailmapEntry{properName: names[0], commitEmail: emails[0]}
+		case 2:
+			// [Proper Name] <proper@email> [Commit Name] <commit@email>
+			e = mailmapEntry{
+				properName:  names[0],
+				properEmail: emails[0],
+				commitName:  names[1],
+				commitEmail: emails[1],
+			}
+		default:
+			continue
+		}
+		e.commitEmail = strings.ToLower(e.commitEmail)
+		m.entries = append(m.entries, e)
+	}
+	return m
+}
+
+// resolve returns the canonical name and email for a commit identity
+// As in git, an entry naming both the commit name and email wins over one with only the email
+func (m *mailmap) resolve(name string, email string) (string, string) {
+	if m == nil {
+		return name, email
+	}
+
+	lowerEmail := strings.ToLower(email)
+	var best *mailmapEntry
+	for i := range m.entries {
+		e := &m.entries[i]
+		if e.commitEmail != lowerEmail {
+			continue
+		}
+		if e.commitName != "" {
+			if e.commitName == name {
+				best = e
+				break
+			}
+			continue
+		}
+		if best == nil {
+			best = e
+		}
+	}
+
+	if best == nil {
+		return name, email
+	}
+	if best.properName != "" {
+		name = best.properName
+	}
+	if best.properEmail != "" {
+		email = best.properEmail
+	}
+	return name, email
+}
+
+// readMailmapFile parses a mailmap-format file, a missing file gives an empty map
+func readMailmapFile(filePath string) (*mailmap, error) {
+	content, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return &mailmap{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseMailmap(string(content)), nil
+}
+
+// loadRepoMailmap reads a repository's .mailmap
+// A checkout uses the file in its working tree, like git does; a bare repository
+// has no working tree, so the copy committed at HEAD is used instead
+func loadRepoMailmap(repo *git.Repository, r foundRepo) *mailmap {
+	if r.kind != kindBare {
+		if content, err := os.ReadFile(filepath.Join(r.path, ".mailmap")); err == nil {
+			return parseMailmap(string(content))
+		}
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil
+	}
+	file, err := commit.File(".mailmap")
+	if err != nil {
+		return nil
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return nil
+	}
+	return parseMailmap(content)
+}
+
+// identityMatcher decides whether a commit was written by the person stats reports on
+type identityMatcher struct {
+	emails map[string]bool // canonical emails in lower case
+	global *mailmap        // the user's identities file
+}
+
+// newIdentityMatcher prepares matching for the -email values
+// Each email is put through the identities file too, so asking for any one
+// of a person's addresses matches all the others mapped to the same person
+func newIdentityMatcher(emails []string, global *mailmap) *identityMatcher {
+	m := &identityMatcher{emails: make(map[string]bool), global: global}
+	for _, email := range emails {
+		m.emails[strings.ToLower(email)] = true
+		_, canonical := global.resolve("", email)
+		m.emails[strings.ToLower(canonical)] = true
+	}
+	return m
+}
+
+// matches reports whether a commit author is one of the identities we look for
+// The email is checked as recorded, after the repository's .mailmap,
+// and after the global identities file on top of that
+func (m *identityMatcher) matches(repoMap *mailmap, name string, email string) bool {
+	if m.emails[strings.ToLower(email)] {
+		return true
+	}
+	name, email = repoMap.resolve(name, email)
+	if m.emails[strings.ToLower(email)] {
+		return true
+	}
+	_, email = m.global.resolve(name, email)
+	return m.emails[strings.ToLower(email)]
+}
+
+// loadIdentities reads the global identities file from the config directory
+func loadIdentities(paths configPaths) (*mailmap, error) {
+	return readMailmapFile(filepath.Join(paths.dir, identitiesFileName))
+}
+
+// Where the email a repository is counted for came from
+const (
+	sourceFlag         = "-email"
+	sourceRepoConfig   = "repository git config"
+	sourceGlobalConfig = "global git config"
+)
+
+// authorIdentity is the email a repository's commits are counted for, and where it came from
+// Emails is empty, and Source too, when there was no -email and no user.email anywhere
+type authorIdentity struct {
+	Emails []string `json:"emails"`
+	Source string   `json:"source"`
+}
+
+// authorResolver picks the identity to count in each repository
+// -email is used everywhere when given; otherwise a repository's own user.email wins over
+// the global one, so work repositories set up with a work address count that address
+type authorResolver struct {
+	identity   authorIdentity   // from -email or the global git config
+	explicit   bool             // identity came from -email and applies to every repository
+	identities *mailmap         // the user's identities file, see loadIdentities
+	matcher    *identityMatcher // matches identity, shared by every repository using it
+}
+
+// newAuthorResolver prepares the identities for the -email values, or for the
+// user.email of the global git config (~/.gitconfig) when there are none
+func newAuthorResolver(emails []string, identities *mailmap) *authorResolver {
+	a := &authorResolver{identities: identities}
+	global := ""
+	if len(emails) == 0 {
+		global = globalUserEmail()
+	}
+	switch {
+	case len(emails) > 0:
+		a.identity = authorIdentity{Emails: emails, Source: sourceFlag}
+		a.explicit = true
+	case global != "":
+		a.identity = authorIdentity{Emails: []string{global}, Source: sourceGlobalConfig}
+	default:
+		a.identity = authorIdentity{Emails: []string{}}
+	}
+	a.matcher = newIdentityMatcher(a.identity.Emails, identities)
+	return a
+}
+
+// forRepo returns the matcher for a repository and the identity it matches
+func (a *authorResolver) forRepo(repo *git.Repository) (*identityMatcher, authorIdentity) {
+	if a.explicit {
+		return a.matcher, a.identity
+	}
+	// repo.Config() reads only the repository's own .git/config
+	cfg, err := repo.Config()
+	if err != nil || cfg.User.Email == "" {
+		return a.matcher, a.identity
+	}
+	for _, email := range a.identity.Emails {
+		if strings.EqualFold(email, cfg.User.Email) {
+			return a.matcher, a.identity
+		}
+	}
+	local := authorIdentity{Emails: []string{cfg.User.Email}, Source: sourceRepoConfig}
+	return newIdentityMatcher(local.Emails, a.identities), local
+}
+
+// globalUserEmail returns user.email from the global git config, or the system one
+// when the global config doesn't set it; "" when neither does
+func globalUserEmail() string {
+	for _, scope := range []config.Scope{config.GlobalScope, config.SystemScope} {
+		cfg, err := config.LoadConfig(scope)
+		if err == nil && cfg.User.Email != "" {
+			return cfg.User.Email
+		}
+	}
+	return ""
+}
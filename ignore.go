@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	// gitignore is go-git's implementation of the .gitignore pattern syntax
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// defaultExcludes are the directories -add skips when nothing says otherwise
+// They are added before any other rule, so "!vendor/" in the ignore file
+// or an -exclude flag is enough to scan them again
+var defaultExcludes = []string{
+	"vendor/",
+	"node_modules/",
+}
+
+// excludeRules decides which directories the walker should not descend into
+// Patterns follow .gitignore syntax and are matched against paths relative to the scan root
+type excludeRules struct {
+	matcher gitignore.Matcher
+}
+
+// loadExcludeRules builds the exclusion rules for a scan
+// Patterns are applied from lowest to highest priority:
+//  1. the built-in defaults
+//  2. the lines of ignoreFile, if it exists
+//  3. the -exclude flags, in the order they were given
+//
+// As in .gitignore, the last matching pattern wins
+func loadExcludeRules(ignoreFile string, flagPatterns []string) (*excludeRules, error) {
+	var lines []string
+	lines = append(lines, defaultExcludes...)
+
+	fileLines, err := readIgnoreFile(ignoreFile)
+	if err != nil {
+		return nil, err
+	}
+	lines = append(lines, fileLines...)
+	lines = append(lines, flagPatterns...)
+
+	var patterns []gitignore.Pattern
+	for _, line := range lines {
+		// A nil domain means the pattern applies from the scan root downwards
+		patterns = append(patterns, gitignore.ParsePattern(line, nil))
+	}
+
+	return &excludeRules{matcher: gitignore.NewMatcher(patterns)}, nil
+}
+
+// readIgnoreFile returns the patterns in an ignore file, skipping comments and blank lines
+// A missing file is not an error, it simply has no patterns
+func readIgnoreFile(filePath string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// excluded reports whether a directory should be skipped
+// rel holds the path components below the scan root, e.g. ["src", "vendor"]
+func (r *excludeRules) excluded(rel []string) bool {
+	return r.matcher.Match(rel, true)
+}
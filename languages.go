@@ -0,0 +1,263 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// The -by values: how the file type table groups files
+const (
+	byExtension = "extension" // raw extensions like .go or .tar.gz, and well-known names like Makefile
+	byLanguage  = "language"  // languages like Go, TypeScript or YAML
+)
+
+// otherLanguage is where files of no known language are counted
+const otherLanguage = "Other"
+
+// languageByFilename maps well-known files without a telling extension to a language
+// Lookups are case-sensitive, like Linguist's: a shell script named "build" isn't Starlark
+var languageByFilename = map[string]string{
+	"Makefile":           "Makefile",
+	"GNUmakefile":        "Makefile",
+	"Dockerfile":         "Dockerfile",
+	"Containerfile":      "Dockerfile",
+	"Jenkinsfile":        "Groovy",
+	"Vagrantfile":        "Ruby",
+	"Gemfile":            "Ruby",
+	"Rakefile":           "Ruby",
+	"Podfile":            "Ruby",
+	"BUILD":              "Starlark",
+	"BUILD.bazel":        "Starlark",
+	"WORKSPACE":          "Starlark",
+	"CMakeLists.txt":     "CMake",
+	"go.mod":             "Go Module",
+	"go.sum":             "Go Checksums",
+	"Cargo.lock":         "TOML",
+	"LICENSE":            "Text",
+	"LICENCE":            "Text",
+	"COPYING":            "Text",
+	"NOTICE":             "Text",
+	"AUTHORS":            "Text",
+	"CODEOWNERS":         "Text",
+	"README":             "Text",
+	".gitignore":         "Ignore List",
+	".dockerignore":      "Ignore List",
+	".npmignore":         "Ignore List",
+	".gitattributes":     "Git Attributes",
+	".gitmodules":        "Git Config",
+	".mailmap":           "Git Mailmap",
+	".editorconfig":      "EditorConfig",
+	".bashrc":            "Shell",
+	".zshrc":             "Shell",
+	".profile":           "Shell",
+	".env":               "Dotenv",
+	"package-lock.json":  "JSON",
+	"yarn.lock":          "YAML",
+	"pnpm-lock.yaml":     "YAML",
+	"requirements.txt":   "Pip Requirements",
+	"Pipfile":            "TOML",
+	"pyproject.toml":     "TOML",
+	"tsconfig.json":      "JSON with Comments",
+	".eslintrc":          "JSON with Comments",
+	".prettierrc":        "JSON",
+	"nginx.conf":         "Nginx",
+	"docker-compose.yml": "YAML",
+}
+
+// multiPartExtensions are extensions made of several dotted parts
+// They are checked before the last part alone, so foo.tar.gz is .tar.gz and not .gz
+var multiPartExtensions = map[string]string{
+	".tar.gz":    "Archive",
+	".tar.bz2":   "Archive",
+	".tar.xz":    "Archive",
+	".d.ts":      "TypeScript",
+	".min.js":    "JavaScript",
+	".min.css":   "CSS",
+	".pb.go":     "Go",
+	".test.ts":   "TypeScript",
+	".test.js":   "JavaScript",
+	".spec.ts":   "TypeScript",
+	".spec.js":   "JavaScript",
+	".blade.php": "Blade",
+}
+
+// languageByExtension maps single extensions, in lower case, to languages
+// .h is shared by C, C++ and Objective-C, and is counted as the "C/C++ Header" it
+// is far more often than not
+var languageByExtension = map[string]string{
+	".go":         "Go",
+	".c":          "C",
+	".h":          "C/C++ Header",
+	".cc":         "C++",
+	".cpp":        "C++",
+	".cxx":        "C++",
+	".hh":         "C++",
+	".hpp":        "C++",
+	".hxx":        "C++",
+	".m":          "Objective-C",
+	".mm":         "Objective-C++",
+	".cs":         "C#",
+	".java":       "Java",
+	".kt":         "Kotlin",
+	".kts":        "Kotlin",
+	".scala":      "Scala",
+	".groovy":     "Groovy",
+	".gradle":     "Groovy",
+	".clj":        "Clojure",
+	".rs":         "Rust",
+	".swift":      "Swift",
+	".zig":        "Zig",
+	".py":         "Python",
+	".pyi":        "Python",
+	".ipynb":      "Jupyter Notebook",
+	".rb":         "Ruby",
+	".erb":        "HTML+ERB",
+	".php":        "PHP",
+	".pl":         "Perl",
+	".pm":         "Perl",
+	".lua":        "Lua",
+	".r":          "R",
+	".jl":         "Julia",
+	".ex":         "Elixir",
+	".exs":        "Elixir",
+	".erl":        "Erlang",
+	".hs":         "Haskell",
+	".ml":         "OCaml",
+	".fs":         "F#",
+	".dart":       "Dart",
+	".js":         "JavaScript",
+	".mjs":        "JavaScript",
+	".cjs":        "JavaScript",
+	".jsx":        "JavaScript",
+	".ts":         "TypeScript",
+	".mts":        "TypeScript",
+	".cts":        "TypeScript",
+	".tsx":        "TypeScript",
+	".vue":        "Vue",
+	".svelte":     "Svelte",
+	".html":       "HTML",
+	".htm":        "HTML",
+	".css":        "CSS",
+	".scss":       "SCSS",
+	".sass":       "Sass",
+	".less":       "Less",
+	".sh":         "Shell",
+	".bash":       "Shell",
+	".zsh":        "Shell",
+	".fish":       "Fish",
+	".ps1":        "PowerShell",
+	".bat":        "Batchfile",
+	".cmd":        "Batchfile",
+	".sql":        "SQL",
+	".proto":      "Protocol Buffer",
+	".graphql":    "GraphQL",
+	".tf":         "HCL",
+	".hcl":        "HCL",
+	".nix":        "Nix",
+	".json":       "JSON",
+	".jsonc":      "JSON with Comments",
+	".yaml":       "YAML",
+	".yml":        "YAML",
+	".toml":       "TOML",
+	".ini":        "INI",
+	".cfg":        "INI",
+	".xml":        "XML",
+	".csv":        "CSV",
+	".md":         "Markdown",
+	".markdown":   "Markdown",
+	".rst":        "reStructuredText",
+	".adoc":       "AsciiDoc",
+	".tex":        "TeX",
+	".txt":        "Text",
+	".mod":        "Go Module",
+	".cmake":      "CMake",
+	".mk":         "Makefile",
+	".dockerfile": "Dockerfile",
+	".svg":        "SVG",
+	".png":        "Image",
+	".jpg":        "Image",
+	".jpeg":       "Image",
+	".gif":        "Image",
+	".webp":       "Image",
+	".ico":        "Image",
+	".map":        "Source Map",
+	".lock":       "Lockfile",
+	".zip":        "Archive",
+	".gz":         "Archive",
+	".tgz":        "Archive",
+	".pdf":        "PDF",
+}
+
+// fileExtension returns the key a file is counted under with -by extension
+// Well-known files are counted under their own name (Makefile, .gitignore, go.mod),
+// spelled as in languageByFilename so makefile and Makefile are one row. Multi-part
+// extensions are kept whole (.tar.gz, .d.ts) and other extensions are lower case,
+// so .YML and .yml are one row
+func fileExtension(filename string) string {
+	base := path.Base(filename)
+	if name, _, ok := lookupFilename(base); ok {
+		return name
+	}
+
+	lower := strings.ToLower(base)
+	for ext := range multiPartExtensions {
+		if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+			return ext
+		}
+	}
+
+	ext := path.Ext(lower)
+	// A name like ".envrc" is all extension, which makes it a dotfile rather than a type
+	if ext == "" || ext == lower {
+		return "no_extension"
+	}
+	return ext
+}
+
+// fileLanguage returns the language a file is counted under with -by language
+func fileLanguage(filename string) string {
+	base := path.Base(filename)
+	if _, lang, ok := lookupFilename(base); ok {
+		return lang
+	}
+
+	lower := strings.ToLower(base)
+	for ext, lang := range multiPartExtensions {
+		if strings.HasSuffix(lower, ext) && len(lower) > len(ext) {
+			return lang
+		}
+	}
+
+	if lang, ok := languageByExtension[path.Ext(lower)]; ok {
+		return lang
+	}
+	return otherLanguage
+}
+
+// lowerCaseFilenames are the well-known names also found in lower case, mapped to
+// their entry in languageByFilename; make reads "makefile" as well as "Makefile"
+var lowerCaseFilenames = map[string]string{
+	"makefile":      "Makefile",
+	"dockerfile":    "Dockerfile",
+	"containerfile": "Containerfile",
+}
+
+// lookupFilename finds a well-known file name, exactly or as one of lowerCaseFilenames
+// It returns the name as spelled in languageByFilename, and its language
+func lookupFilename(base string) (name string, lang string, ok bool) {
+	if lang, ok := languageByFilename[base]; ok {
+		return base, lang, true
+	}
+	if name, ok := lowerCaseFilenames[base]; ok {
+		return name, languageByFilename[name], true
+	}
+	return "", "", false
+}
+
+// fileTypeKey returns the row a file is counted under for a -by value
+func fileTypeKey(filename string, by string) string {
+	if by == byLanguage {
+		return fileLanguage(filename)
+	}
+	return fileExtension(filename)
+}
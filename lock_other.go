@@ -0,0 +1,34 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockTimeout is how long acquireLock waits for another process before giving up
+const lockTimeout = 30 * time.Second
+
+// acquireLock takes an exclusive lock on lockPath for platforms without flock
+// The lock is the file itself: whoever manages to create it holds the lock,
+// and removing it releases the lock
+func acquireLock(lockPath string) (release func() error, err error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		// O_EXCL makes the create fail if the file is already there, atomically
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() error { return os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is held by another process; remove it if no other scan is running", lockPath)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -6,15 +6,69 @@ package main
 // The 'flag' package is used to handle command-line arguments
 import (
     "flag"
+    "log"
+    "os"
+    "strings"
+    "time"
 )
 
+// exitPartial is the exit code when stats couldn't read every repository
+// log.Fatal exits with 1 and the flag package with 2 on a bad command line,
+// so 3 tells a script the report printed but is missing some repositories
+const exitPartial = 3
+
+// stringList is a flag that can be given several times, e.g. -exclude a -exclude b
+// It implements the flag.Value interface: String() and Set()
+type stringList []string
+
+// String returns the collected values, used by flag when printing defaults
+func (s *stringList) String() string {
+    return strings.Join(*s, ",")
+}
+
+// Set is called by flag once for every occurrence of the flag
+func (s *stringList) Set(value string) error {
+    *s = append(*s, value)
+    return nil
+}
+
 // main() function is the entry point of the program
 // Every executable Go program must have exactly one main() function
 func main() {
     // Declare variables to store command-line arguments
     // These are string variables that will hold the folder path and email
     var folder string
-    var email string
+    var emails stringList
+    var workers int
+    var excludes stringList
+    var maxDepth int
+    var followSymlinks bool
+    var oneFileSystem bool
+    var strict bool
+    var list bool
+    var remove string
+    var prune bool
+    var repoPath string
+    var alias string
+    var configDir string
+    var registryFile string
+    var tags stringList
+    var untags stringList
+    var since string
+    var until string
+    var tz string
+    var year int
+    var years string
+    var jsonOutput bool
+    var refs string
+    var patchID bool
+    var metric string
+    var merges string
+    var by string
+    var includeGenerated bool
+    var noCache bool
+    var jobs int
+    var authors bool
     
     // flag.StringVar sets up command-line flags with default values
     // Parameters are:
@@ -23,20 +77,200 @@ func main() {
     // 3. Default value if flag is not provided
     // 4. Help text describing the flag
     flag.StringVar(&folder, "add", "", "add a new folder to scan for Git repositories")
-    flag.StringVar(&email, "email", "your@email.com", "the email to scan")
+    flag.Var(&emails, "email", "the email to scan, matched case-insensitively (repeatable, default user.email from each repository's git config, then the global one)")
+    flag.IntVar(&workers, "workers", defaultScanWorkers(), "number of directories read in parallel during -add")
+    // flag.Var accepts any type implementing flag.Value, which lets -exclude repeat
+    flag.Var(&excludes, "exclude", "gitignore-style pattern of directories to skip during -add (repeatable)")
+    flag.IntVar(&maxDepth, "max-depth", -1, "how many directory levels below the -add folder to scan (-1 for no limit)")
+    flag.BoolVar(&followSymlinks, "follow-symlinks", false, "descend into symlinked directories during -add")
+    flag.BoolVar(&oneFileSystem, "one-file-system", false, "don't cross into other mounted filesystems during -add")
+    flag.BoolVar(&strict, "strict", false, "stop -add at the first unreadable directory instead of skipping it")
+    flag.BoolVar(&authors, "authors", false, "list every author name and email in the registered repositories, with commit counts and dates")
+    flag.BoolVar(&list, "list", false, "list registered repositories and whether they still exist")
+    flag.StringVar(&remove, "remove", "", "unregister a repository, or every repository under a folder")
+    flag.BoolVar(&prune, "prune", false, "unregister repositories whose path no longer holds a git repository")
+    flag.StringVar(&repoPath, "repo", "", "registered repository, or folder of repositories, to edit with -alias, -tag or -untag")
+    flag.StringVar(&alias, "alias", "", "short display name for the -repo repository (empty to remove it)")
+    flag.StringVar(&configDir, "config", "", "configuration directory (default $XDG_CONFIG_HOME/gitcontrib)")
+    flag.StringVar(&registryFile, "registry", "", "repository registry file (default $"+registryEnvVar+", then the -config directory)")
+    flag.Var(&tags, "tag", "with -add or -repo: tag to add; otherwise: only report on repositories matching this tag expression, e.g. 'work && !client-x' (repeatable)")
+    flag.Var(&untags, "untag", "tag to remove from the -repo repositories (repeatable)")
+    flag.StringVar(&since, "since", "", "first day to report on: 2025-03-14, 2025-03, 2025, 3m, 1y or a period like last-quarter (default 6 months ago)")
+    flag.StringVar(&until, "until", "", "last day to report on, same formats as -since (default today)")
+    flag.IntVar(&year, "year", 0, "show the whole calendar year, January to December, like a GitHub profile")
+    flag.StringVar(&years, "years", "", "show one calendar per year, either the last N years (e.g. 3) or a span like 2021-2025")
+    flag.BoolVar(&jsonOutput, "json", false, "print the daily counts, streaks and file types as JSON instead of the heatmap")
+    flag.StringVar(&refs, "refs", refsHead, "history to count: head, local (all local branches), all (branches, remotes and tags) or a glob like 'feature/*'")
+    flag.BoolVar(&patchID, "patch-id", false, "count a cherry-picked or rebased change once, even under another commit hash")
+    flag.StringVar(&metric, "metric", metricFiles, "what the file type table counts: files, added, deleted or changed (lines)")
+    flag.StringVar(&merges, "merges", mergesSkip, "merge commits in the file type table: skip, or first-parent to count their whole diff")
+    flag.StringVar(&by, "by", byExtension, "group the file type table by extension or by language")
+    flag.BoolVar(&includeGenerated, "include-generated", false, "count generated and vendored files (lockfiles, minified bundles, linguist-generated) in the file type table")
+    flag.IntVar(&jobs, "jobs", defaultStatsJobs(), "number of repositories read in parallel by stats")
+    flag.BoolVar(&noCache, "no-cache", false, "read every repository's full history instead of using and updating the commit cache")
+    flag.StringVar(&tz, "tz", "Local", "time zone deciding which day a commit counts on, e.g. UTC or Europe/Berlin")
     
     // Parse the command-line flags
     // This must be called after flags are defined but before they are accessed
     flag.Parse()
     
+    // Work out where the registry and ignore file live, see config.go
+    paths, err := resolveConfigPaths(configDir, registryFile)
+    if err != nil {
+        log.Fatal(err)
+    }
+    
     // If a folder was provided (flag -add was used)
     if folder != "" {
+        // With -add, every -tag is a plain tag name to attach to the repositories found
+        // normalizeTags is defined in tags.go
+        addTags, err := normalizeTags(tags)
+        if err != nil {
+            log.Fatal(err)
+        }
+        
         // Call scan() function with the folder path and return
-        scan(folder)
+        scan(paths, folder, scanOptions{
+            workers:        workers,
+            excludes:       excludes,
+            maxDepth:       maxDepth,
+            followSymlinks: followSymlinks,
+            oneFileSystem:  oneFileSystem,
+            strict:         strict,
+            tags:           addTags,
+        })
+        return
+    }
+    
+    // Editing registered repositories, see editRepos in registry.go
+    if repoPath != "" {
+        var edit repoEdit
+        // flag.Visit only walks flags that were actually given, which
+        // lets "-alias ''" clear an alias while a missing -alias leaves it alone
+        flag.Visit(func(f *flag.Flag) {
+            if f.Name == "alias" {
+                edit.alias = &alias
+            }
+        })
+        if edit.addTags, err = normalizeTags(tags); err != nil {
+            log.Fatal(err)
+        }
+        if edit.removeTags, err = normalizeTags(untags); err != nil {
+            log.Fatal(err)
+        }
+        if edit.alias == nil && len(edit.addTags) == 0 && len(edit.removeTags) == 0 {
+            log.Fatal("-repo needs something to change: -alias, -tag or -untag")
+        }
+        editRepos(paths, repoPath, edit)
+        return
+    }
+    
+    // Everywhere else, -tag filters which repositories are looked at
+    // parseTagFilters is defined in tags.go
+    filter, err := parseTagFilters(tags)
+    if err != nil {
+        log.Fatal(err)
+    }
+    
+    // The commit cache lives in the user's cache directory, see config.go and cache.go
+    cacheDir := commitCacheDir()
+    if noCache {
+        cacheDir = ""
+    }
+    
+    // Registry management commands, defined in registry.go
+    if list {
+        listRepos(paths, filter, cacheDir, jobs)
+        return
+    }
+    if remove != "" {
+        removeRepos(paths, remove)
+        return
+    }
+    if prune {
+        pruneRepos(paths)
         return
     }
+    // checkRefsOption is defined in history.go
+    if err := checkRefsOption(refs); err != nil {
+        log.Fatal(err)
+    }
     
-    // If no folder was provided, call stats() with the email
-    // This is the default behavior when run without the -add flag
-    stats(email)
+    switch metric {
+    case metricFiles, metricAdded, metricDeleted, metricChanged:
+    default:
+        log.Fatalf("-metric must be files, added, deleted or changed, not %q", metric)
+    }
+    if merges != mergesSkip && merges != mergesFirstParent {
+        log.Fatalf("-merges must be skip or first-parent, not %q", merges)
+    }
+    if by != byExtension && by != byLanguage {
+        log.Fatalf("-by must be extension or language, not %q", by)
+    }
+    
+    // Commits are counted on the day they were made in the -tz time zone
+    // time.LoadLocation understands "Local", "UTC" and IANA names like "America/New_York"
+    loc, err := time.LoadLocation(tz)
+    if err != nil {
+        log.Fatalf("-tz: %v", err)
+    }
+    
+    // Work out the days to report on, see daterange.go
+    today := dateOf(time.Now().In(loc))
+    var rng dateRange
+    var yearList []int
+    switch {
+    case (year != 0 || years != "") && (since != "" || until != ""):
+        log.Fatal("-year and -years can't be combined with -since or -until")
+    case year != 0 && years != "":
+        log.Fatal("use either -year or -years")
+    case year != 0:
+        yearList = []int{year}
+    case years != "":
+        if yearList, err = parseYears(years, today.year); err != nil {
+            log.Fatal(err)
+        }
+    }
+    if len(yearList) > 0 {
+        // Read commits for every year at once, oldest January to newest December
+        rng = dateRange{
+            start: yearRange(yearList[len(yearList)-1]).start,
+            end:   yearRange(yearList[0]).end,
+        }
+    } else if rng, err = parseDateRange(since, until, today); err != nil {
+        log.Fatal(err)
+    }
+    
+    opts := statsOptions{
+        emails:           emails,
+        filter:           filter,
+        filterText:       strings.Join(tags, " && "),
+        dateRange:        rng,
+        location:         loc,
+        years:            yearList,
+        json:             jsonOutput,
+        refs:             refs,
+        patchID:          patchID,
+        metric:           metric,
+        merges:           merges,
+        by:               by,
+        includeGenerated: includeGenerated,
+        cacheDir:         cacheDir,
+        jobs:             jobs,
+    }
+    
+    // -authors lists who committed, to find the right -email; listAuthors is defined in authors.go
+    var complete bool
+    if authors {
+        complete = listAuthors(paths, opts)
+    } else {
+        // If no folder was provided, call stats() with the email
+        // This is the default behavior when run without the -add flag
+        // Without -email, stats counts the user.email of each repository's git config,
+        // or of the global git config (see authorResolver in identity.go)
+        complete = stats(paths, opts)
+    }
+    if !complete {
+        os.Exit(exitPartial)
+    }
 }
\ No newline at end of file
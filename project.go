@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// projectIdentity is what tells two checkouts apart as projects
+// Clones and forks of one project share their first commit, and clones of one
+// remote share its URL, so two repositories with either in common are the same project
+type projectIdentity struct {
+	roots   []string // hashes of the commits without parents, reachable from HEAD
+	remotes []string // remote URLs, normalized with normalizeRemoteURL
+}
+
+// readProjectIdentity works out the identity of a repository
+// The roots come from the commit cache (see cache.go), so only commits new since the
+// last run are read; a repository with no commits and no remotes has an empty identity
+// and is only ever grouped with itself
+func readProjectIdentity(r foundRepo, cacheDir string) (id projectIdentity, err error) {
+	defer recoverFailure(&err)
+	repo, err := openRepository(r)
+	if err != nil {
+		return id, err
+	}
+
+	// origin comes first, as it is the one a project is named after, then the others by name
+	if remotes, err := repo.Remotes(); err == nil {
+		sort.Slice(remotes, func(i, j int) bool {
+			a, b := remotes[i].Config().Name, remotes[j].Config().Name
+			if (a == "origin") != (b == "origin") {
+				return a == "origin"
+			}
+			return a < b
+		})
+		for _, remote := range remotes {
+			for _, u := range remote.Config().URLs {
+				if u = normalizeRemoteURL(u); !containsString(id.remotes, u) {
+					id.remotes = append(id.remotes, u)
+				}
+			}
+		}
+	}
+
+	// The roots are the commits without parents in the history of HEAD
+	starts, err := startCommits(repo, refsHead)
+	if err != nil {
+		return id, err
+	}
+	cache := loadCommitCache(cacheDir, r, refsHead)
+	order, err := cache.update(repo, starts)
+	if err != nil {
+		return id, err
+	}
+	for _, hash := range order {
+		if len(cache.Commits[hash].Parents) == 0 {
+			id.roots = append(id.roots, hash.String())
+		}
+	}
+	// saveCache is defined in stats.go
+	saveCache(cache, r)
+
+	sort.Strings(id.roots)
+	return id, nil
+}
+
+// normalizeRemoteURL reduces the ways of writing a remote to one, so that
+// git@github.com:user/repo.git, https://github.com/user/repo and
+// ssh://git@github.com/user/repo.git all become github.com/user/repo
+func normalizeRemoteURL(remote string) string {
+	remote = strings.TrimSpace(remote)
+
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" && u.Host != "" {
+		remote = strings.ToLower(u.Hostname()) + u.Path
+	} else if at := strings.Index(remote, "@"); at >= 0 && strings.Contains(remote[at:], ":") {
+		// scp-like syntax: user@host:path
+		host, path, _ := strings.Cut(remote[at+1:], ":")
+		remote = strings.ToLower(host) + "/" + strings.TrimPrefix(path, "/")
+	}
+
+	remote = strings.TrimSuffix(remote, "/")
+	remote = strings.TrimSuffix(remote, ".git")
+	return remote
+}
+
+// setIdentity records a project identity on a registry entry
+func (e *registryEntry) setIdentity(id projectIdentity) {
+	e.Roots = id.roots
+	e.Remotes = id.remotes
+	e.Identified = true
+}
+
+// identity returns the project identity recorded for an entry
+// It is empty until resolveIdentities has read it
+func (e *registryEntry) identity() projectIdentity {
+	return projectIdentity{roots: e.Roots, remotes: e.Remotes}
+}
+
+// resolveIdentities reads the identity of every entry that has none recorded yet,
+// jobs at a time, and saves them to the registry so later runs don't read them again
+// Entries registered before identities were recorded, or rescanned since, have none
+// An entry that can't be read keeps an empty identity and is tried again next time
+// The identities are used for this run even when the registry can't be written,
+// e.g. on a read-only mount; saving them is only a speed-up, so that only warns
+func resolveIdentities(paths configPaths, entries []*registryEntry, cacheDir string, jobs int) {
+	var missing []*registryEntry
+	for _, e := range entries {
+		if !e.Identified {
+			missing = append(missing, e)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	identities := make([]projectIdentity, len(missing))
+	errs := make([]error, len(missing))
+	// runJobs is defined in stats.go
+	runJobs(len(missing), jobs, func(i int) {
+		identities[i], errs[i] = readProjectIdentity(missing[i].repo(), cacheDir)
+	})
+
+	found := make(map[string]projectIdentity)
+	for i, e := range missing {
+		if errs[i] == nil {
+			e.setIdentity(identities[i])
+			found[e.Path] = identities[i]
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+	// The registry is read again under the lock, as a scan may have changed it meanwhile
+	_, err := updateRegistry(paths.registry, func(reg *registry) error {
+		for path, id := range found {
+			if e := reg.find(path); e != nil {
+				e.setIdentity(id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: can't save project identities to the registry: %v\n", err)
+	}
+}
+
+// projectGroup is a set of registered repositories holding the same project
+type projectGroup struct {
+	entries []*registryEntry
+	remotes []string
+}
+
+// name describes a project by its first remote, or by its first clone without one
+func (g projectGroup) name() string {
+	if len(g.remotes) > 0 {
+		return g.remotes[0]
+	}
+	return g.entries[0].displayName()
+}
+
+// groupProjects puts entries with a root commit or remote URL in common into one group
+// Groups and the entries within them keep the order of the registry
+func groupProjects(entries []*registryEntry) []projectGroup {
+	// A small union-find over entry indexes: parent[i] == i marks the head of a group
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	// Join every entry to the first one seen with the same root or remote
+	// The lower index becomes the head, so groups come out in registry order
+	owners := make(map[string]int)
+	identities := make([]projectIdentity, len(entries))
+	for i, e := range entries {
+		identities[i] = e.identity()
+		var keys []string
+		for _, root := range identities[i].roots {
+			keys = append(keys, "root "+root)
+		}
+		for _, remote := range identities[i].remotes {
+			keys = append(keys, "remote "+remote)
+		}
+		for _, key := range keys {
+			owner, ok := owners[key]
+			if !ok {
+				owners[key] = i
+				continue
+			}
+			a, b := find(owner), find(i)
+			if a > b {
+				a, b = b, a
+			}
+			parent[b] = a
+		}
+	}
+
+	var groups []projectGroup
+	index := make(map[int]int)
+	for i, e := range entries {
+		head := find(i)
+		g, ok := index[head]
+		if !ok {
+			g = len(groups)
+			index[head] = g
+			groups = append(groups, projectGroup{})
+		}
+		groups[g].entries = append(groups[g].entries, e)
+		for _, remote := range identities[i].remotes {
+			if !containsString(groups[g].remotes, remote) {
+				groups[g].remotes = append(groups[g].remotes, remote)
+			}
+		}
+	}
+	return groups
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// registryVersion is the version of the file format written by this build
+// Bump it when the format changes in a way older builds can't read
+const registryVersion = 1
+
+// registryEntry is everything we remember about one registered repository
+type registryEntry struct {
+	Path        string    `json:"path"`
+	Kind        repoKind  `json:"kind"`
+	Alias       string    `json:"alias,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Added       time.Time `json:"added"`
+	LastScanned time.Time `json:"last_scanned"`
+	// Roots and Remotes identify the project, see project.go
+	// Identified is set once they have been read, even if both came out empty
+	Roots      []string `json:"roots,omitempty"`
+	Remotes    []string `json:"remotes,omitempty"`
+	Identified bool     `json:"identified,omitempty"`
+}
+
+// registry is the list of repositories stats reports on
+// It is stored as JSON, see resolveConfigPaths in config.go for where
+type registry struct {
+	Version int              `json:"version"`
+	Repos   []*registryEntry `json:"repos"`
+
+	// filePath is where the registry was loaded from and where save() writes it
+	// It has no json tag and is unexported, so encoding/json leaves it alone
+	filePath string
+}
+
+// repo converts an entry into the foundRepo the rest of the code opens and walks
+func (e *registryEntry) repo() foundRepo {
+	return foundRepo{path: e.Path, kind: e.Kind}
+}
+
+// displayName is the alias of a repository if it has one, its path otherwise
+func (e *registryEntry) displayName() string {
+	if e.Alias != "" {
+		return e.Alias
+	}
+	return e.Path
+}
+
+// loadRegistry reads the registry at filePath for commands that only look at it
+// A missing file gives an empty registry, and a plain-text list written by older
+// versions is converted to the current format and saved straight away
+// When it can't be saved, e.g. on a read-only mount, the list read is used as it is
+// and the conversion is tried again next time
+func loadRegistry(filePath string) (*registry, error) {
+	reg, legacy, err := readRegistry(filePath)
+	if err != nil || !legacy {
+		return reg, err
+	}
+
+	// updateRegistry always writes the current format, so an empty update is a migration
+	migrated, err := updateRegistry(filePath, func(*registry) error { return nil })
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: can't convert %s to the current format: %v\n", filePath, err)
+		return reg, nil
+	}
+	return migrated, nil
+}
+
+// readRegistry parses the registry file without changing anything on disk
+// legacy is true when the file still holds the old plain-text list
+func readRegistry(filePath string) (reg *registry, legacy bool, err error) {
+	reg = &registry{Version: registryVersion, filePath: filePath}
+
+	content, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return reg, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 {
+		return reg, false, nil
+	}
+
+	// The old format is one path per line, which can never start with a brace
+	if trimmed[0] != '{' {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, false, err
+		}
+		reg.Repos = parseLegacyRegistry(content, info.ModTime())
+		return reg, true, nil
+	}
+
+	if err := json.Unmarshal(content, reg); err != nil {
+		return nil, false, fmt.Errorf("reading %s: %w", filePath, err)
+	}
+	if reg.Version > registryVersion {
+		return nil, false, fmt.Errorf("%s was written by a newer version (format %d, this build reads up to %d)",
+			filePath, reg.Version, registryVersion)
+	}
+	reg.Version = registryVersion
+	return reg, false, nil
+}
+
+// updateRegistry runs a read-modify-write cycle on the registry while holding its lock
+// Several -add runs started at the same time therefore each see the others' changes
+// instead of overwriting them; the registry is only saved if change returns nil
+func updateRegistry(filePath string, change func(reg *registry) error) (*registry, error) {
+	// The first write to a fresh -config or XDG directory has to create it
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, err
+	}
+
+	// acquireLock is defined in lock_unix.go and lock_other.go
+	release, err := acquireLock(filePath + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", filePath, err)
+	}
+	defer release()
+
+	// Read only once the lock is held, so we start from the latest saved version
+	reg, _, err := readRegistry(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := change(reg); err != nil {
+		return nil, err
+	}
+	if err := reg.save(); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// parseLegacyRegistry converts the old newline-separated list into entries
+// The old file didn't record when repositories were added, so the file's
+// modification time is the best guess we have
+func parseLegacyRegistry(content []byte, modTime time.Time) []*registryEntry {
+	var entries []*registryEntry
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		// parseRepoLine is defined in repo.go and understands the "path<TAB>kind" lines
+		r := parseRepoLine(line)
+		if seen[r.path] {
+			continue
+		}
+		seen[r.path] = true
+		entries = append(entries, &registryEntry{
+			Path:        r.path,
+			Kind:        r.kind,
+			Added:       modTime,
+			LastScanned: modTime,
+		})
+	}
+	return entries
+}
+
+// save writes the registry back to the file it was loaded from
+// Callers should hold the registry lock, see updateRegistry
+func (reg *registry) save() error {
+	content, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+	return writeFileAtomic(reg.filePath, content)
+}
+
+// writeFileAtomic replaces filePath with content in one step
+// The content goes to a temporary file in the same directory which is then renamed
+// over filePath; a rename within a directory is atomic, so readers see either
+// the old or the new file, never a half-written one
+func writeFileAtomic(filePath string, content []byte) error {
+	dir := filepath.Dir(filePath)
+	tmp, err := os.CreateTemp(dir, filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Clean up the temporary file on every error path; after the rename this is a no-op
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Sync makes sure the data is on disk before the rename makes it visible
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// CreateTemp uses 0600, keep the permissions our files have always had
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// find returns the entry registered for path, or nil
+func (reg *registry) find(path string) *registryEntry {
+	for _, e := range reg.Repos {
+		if e.Path == path {
+			return e
+		}
+	}
+	return nil
+}
+
+// add registers a repository found by a scan
+// A repository that is already registered keeps its alias, tags and date added,
+// only its kind and last-scanned time are refreshed
+// It returns the entry, and true if the repository wasn't registered before
+func (reg *registry) add(r foundRepo, now time.Time) (*registryEntry, bool) {
+	if e := reg.find(r.path); e != nil {
+		e.Kind = r.kind
+		e.LastScanned = now
+		return e, false
+	}
+
+	e := &registryEntry{
+		Path:        r.path,
+		Kind:        r.kind,
+		Added:       now,
+		LastScanned: now,
+	}
+	reg.Repos = append(reg.Repos, e)
+	return e, true
+}
+
+// removeIf drops every entry for which drop returns true and returns the dropped ones
+func (reg *registry) removeIf(drop func(e *registryEntry) bool) []*registryEntry {
+	var kept, dropped []*registryEntry
+	for _, e := range reg.Repos {
+		if drop(e) {
+			dropped = append(dropped, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	reg.Repos = kept
+	return dropped
+}
+
+// mustLoadRegistry loads the user's registry or exits with the error
+// Commands can't do anything useful without it
+func mustLoadRegistry(paths configPaths) *registry {
+	reg, err := loadRegistry(paths.registry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return reg
+}
+
+// mustUpdateRegistry is updateRegistry on the user's registry, exiting on errors
+func mustUpdateRegistry(paths configPaths, change func(reg *registry) error) *registry {
+	reg, err := updateRegistry(paths.registry, change)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return reg
+}
+
+// repoStatus describes what is currently on disk at a registered path
+type repoStatus string
+
+const (
+	statusOK      repoStatus = "ok"
+	statusMissing repoStatus = "missing"    // the path no longer exists
+	statusNotRepo repoStatus = "not a repo" // the path exists but holds no git repository
+)
+
+// checkRepo looks at a registered repository and reports whether it is still usable
+func checkRepo(r foundRepo) repoStatus {
+	if _, err := os.Stat(r.path); os.IsNotExist(err) {
+		return statusMissing
+	}
+	// openRepository is defined in repo.go; it fails when there is no repository to open
+	if _, err := openRepository(r); err != nil {
+		return statusNotRepo
+	}
+	return statusOK
+}
+
+// listRepos prints every registered repository together with its status
+// Only repositories matching filter are shown; a nil filter shows them all
+// cacheDir and jobs are for reading project identities not recorded yet, see project.go
+func listRepos(paths configPaths, filter tagExpr, cacheDir string, jobs int) {
+	reg := mustLoadRegistry(paths)
+	if len(reg.Repos) == 0 {
+		fmt.Printf("No repositories registered in %s, use -add to scan a folder\n", paths.registry)
+		return
+	}
+
+	// filterEntries is defined in tags.go
+	entries := filterEntries(reg.Repos, filter)
+	for _, e := range entries {
+		fmt.Printf("%-10s %-10s %s%s\n", checkRepo(e.repo()), e.Kind, e.Path, describeEntry(e))
+	}
+
+	// Show which entries are clones of one project, see groupProjects in project.go
+	resolveIdentities(paths, entries, cacheDir, jobs)
+	printed := false
+	for _, g := range groupProjects(entries) {
+		if len(g.entries) < 2 {
+			continue
+		}
+		if !printed {
+			fmt.Printf("\nClones of the same project, counted once by stats:\n")
+			printed = true
+		}
+		fmt.Printf("  %s\n", g.name())
+		for _, e := range g.entries {
+			fmt.Printf("    %s%s\n", e.Path, describeEntry(e))
+		}
+	}
+}
+
+// isUnderPrefix reports whether path is prefix itself or somewhere below it
+// The separator check stops /code/app from matching /code/application
+func isUnderPrefix(path string, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+string(filepath.Separator))
+}
+
+// absPath makes a path given on the command line comparable with registered paths,
+// which are always absolute
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return abs
+}
+
+// removeRepos unregisters a repository, or every repository below a folder
+func removeRepos(paths configPaths, target string) {
+	prefix := absPath(target)
+
+	var removed []*registryEntry
+	mustUpdateRegistry(paths, func(reg *registry) error {
+		removed = reg.removeIf(func(e *registryEntry) bool {
+			return isUnderPrefix(e.Path, prefix)
+		})
+		return nil
+	})
+
+	if len(removed) == 0 {
+		fmt.Printf("No registered repositories under %s\n", prefix)
+		return
+	}
+	for _, e := range removed {
+		fmt.Printf("Removed %s\n", e.Path)
+	}
+	fmt.Printf("\nRemoved %d repositories\n", len(removed))
+}
+
+// pruneRepos unregisters every repository whose path no longer holds a git repository
+func pruneRepos(paths configPaths) {
+	var pruned []*registryEntry
+	statuses := make(map[*registryEntry]repoStatus)
+	mustUpdateRegistry(paths, func(reg *registry) error {
+		pruned = reg.removeIf(func(e *registryEntry) bool {
+			statuses[e] = checkRepo(e.repo())
+			return statuses[e] != statusOK
+		})
+		return nil
+	})
+
+	if len(pruned) == 0 {
+		fmt.Println("Nothing to prune")
+		return
+	}
+	for _, e := range pruned {
+		fmt.Printf("Pruned %s (%s)\n", e.Path, statuses[e])
+	}
+	fmt.Printf("\nPruned %d repositories\n", len(pruned))
+}
+
+// repoEdit is a change to registered repositories requested with -repo
+type repoEdit struct {
+	alias      *string  // new alias, nil to leave it alone, "" to remove it
+	addTags    []string // tags to add, already normalized
+	removeTags []string // tags to remove, already normalized
+}
+
+// editRepos applies an edit to the repository at target, or every repository below it
+// Tags can be changed on a whole folder at once, but an alias names a single repository,
+// so it needs target to be a registered path
+func editRepos(paths configPaths, target string, edit repoEdit) {
+	prefix := absPath(target)
+
+	var edited []*registryEntry
+	mustUpdateRegistry(paths, func(reg *registry) error {
+		if edit.alias != nil {
+			e := reg.find(prefix)
+			if e == nil {
+				return fmt.Errorf("%s is not registered, use -add first", prefix)
+			}
+			e.Alias = *edit.alias
+		}
+
+		for _, e := range reg.Repos {
+			if !isUnderPrefix(e.Path, prefix) {
+				continue
+			}
+			e.addTags(edit.addTags)
+			e.removeTags(edit.removeTags)
+			edited = append(edited, e)
+		}
+		if len(edited) == 0 {
+			return fmt.Errorf("no registered repositories under %s", prefix)
+		}
+		return nil
+	})
+
+	for _, e := range edited {
+		fmt.Printf("%s%s\n", e.Path, describeEntry(e))
+	}
+}
+
+// describeEntry returns the alias and tags of an entry for printing after its path
+// e.g. " (app) [oss, work]", or "" when it has neither
+func describeEntry(e *registryEntry) string {
+	var out string
+	if e.Alias != "" {
+		out += fmt.Sprintf(" (%s)", e.Alias)
+	}
+	if len(e.Tags) > 0 {
+		out += fmt.Sprintf(" [%s]", strings.Join(e.Tags, ", "))
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// repoKind says how a repository is laid out on disk
+type repoKind string
+
+const (
+	kindNormal    repoKind = "normal"    // working tree with a .git directory
+	kindWorktree  repoKind = "worktree"  // linked worktree created by 'git worktree add'
+	kindSubmodule repoKind = "submodule" // submodule whose .git file points into the superproject
+	kindBare      repoKind = "bare"      // repository without a working tree, e.g. project.git
+)
+
+// foundRepo is a repository discovered by the scanner
+type foundRepo struct {
+	path string
+	kind repoKind
+}
+
+// parseRepoLine reads a line of the plain-text repository list used before the JSON registry
+// Normal repositories were written as a bare path, other kinds as "path<TAB>kind"
+func parseRepoLine(line string) foundRepo {
+	// strings.Cut splits around the first tab; found is false for plain paths
+	path, kind, found := strings.Cut(line, "\t")
+	if !found {
+		return foundRepo{path: path, kind: kindNormal}
+	}
+	return foundRepo{path: path, kind: repoKind(kind)}
+}
+
+// readGitFile reads a .git file, which holds a single "gitdir: <path>" line
+// It returns the absolute git directory it points to
+func readGitFile(dotGitPath string) (string, error) {
+	content, err := os.ReadFile(dotGitPath)
+	if err != nil {
+		return "", err
+	}
+
+	line := strings.TrimSpace(string(content))
+	gitDir, found := strings.CutPrefix(line, "gitdir:")
+	if !found {
+		return "", &os.PathError{Op: "parse", Path: dotGitPath, Err: os.ErrInvalid}
+	}
+	gitDir = strings.TrimSpace(gitDir)
+
+	// Relative paths are relative to the directory holding the .git file
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(dotGitPath), gitDir)
+	}
+	return filepath.Clean(gitDir), nil
+}
+
+// kindFromGitDir tells worktrees and submodules apart using the git directory they point to
+// git keeps them under <repo>/.git/worktrees/<name> and <repo>/.git/modules/<name>
+func kindFromGitDir(gitDir string) repoKind {
+	parts := strings.Split(filepath.ToSlash(gitDir), "/")
+	for i := len(parts) - 2; i >= 0; i-- {
+		switch parts[i] {
+		case "worktrees":
+			return kindWorktree
+		case "modules":
+			return kindSubmodule
+		}
+	}
+	// e.g. 'git init --separate-git-dir', which behaves like a normal repository
+	return kindNormal
+}
+
+// isBareRepoDir reports whether a directory's entries look like a bare repository:
+// a HEAD file next to objects and refs directories
+func isBareRepoDir(entries []os.DirEntry) bool {
+	var head, objects, refs bool
+	for _, entry := range entries {
+		switch entry.Name() {
+		case "HEAD":
+			head = !entry.IsDir()
+		case "objects":
+			objects = entry.IsDir()
+		case "refs":
+			refs = entry.IsDir()
+		}
+	}
+	return head && objects && refs
+}
+
+// openRepository opens any kind of repository found by the scanner
+// EnableDotGitCommonDir lets go-git read the objects and refs a worktree shares with its main repository
+func openRepository(r foundRepo) (*git.Repository, error) {
+	return git.PlainOpenWithOptions(r.path, &git.PlainOpenOptions{
+		EnableDotGitCommonDir: true,
+	})
+}
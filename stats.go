@@ -5,240 +5,548 @@ package main
 import (
     // fmt provides formatted I/O operations
     "fmt"
+    // io lets the author hint go to standard output or standard error
+    "io"
+    // log provides simple error reporting that exits the program
+    "log"
+    // os gives access to standard error for warnings
+    "os"
+    // runtime reports the number of CPUs, for the default -jobs
+    "runtime"
     // sort provides sorting functionality for slices
     "sort"
+    // strconv converts numbers to strings
+    "strconv"
+    // strings joins the repository names in the identity lines
+    "strings"
+    // sync coordinates the workers reading repositories
+    "sync"
     // time provides time-related functions
     "time"
-    "strings" // for string manipulation
     // go-git packages for Git operations
     // Note: this is an external package, not part of Go standard library
     "github.com/go-git/go-git/v5"
-    "github.com/go-git/go-git/v5/plumbing/object"
+    "github.com/go-git/go-git/v5/plumbing"
 )
 
-// Constants used throughout the program
-// const is a Go keyword for declaring constants
-const outOfRange = 99999                  // Used as a marker for dates too old
-const daysInLastSixMonths = 183          // Approximately 6 months worth of days
-const weeksInLastSixMonths = 26          // Number of weeks in 6 months
-
 // New features, File Type Stats (most used file types in commits)
+// Name is an extension or a language, depending on -by (see languages.go)
 type FileTypeStats struct {
-    Extension string
-    Count     int
+    Name  string `json:"name"`
+    Count int    `json:"count"`
+}
+
+// statsOptions collects the settings that control what stats reports on
+type statsOptions struct {
+    emails           []string       // only count commits by these authors, matched case-insensitively
+    filter           tagExpr        // only look at repositories matching this tag filter, nil for all (see tags.go)
+    filterText       string         // the filter as the user typed it, for the report header
+    dateRange        dateRange      // the days to report on, from -since and -until (see daterange.go)
+    location         *time.Location // time zone commits are bucketed in, from -tz
+    years            []int          // with -year or -years, one calendar per year, newest first
+    json             bool           // write the counts and summary as JSON instead of drawing them
+    refs             string         // which refs to walk: head, local, all or a glob (see history.go)
+    patchID          bool           // also skip commits whose change was already counted under another hash
+    metric           string         // what the file type table counts, one of the metric* constants
+    merges           string         // how merge commits count in the file type table, one of the merges* constants
+    by               string         // group the file type table by extension or language (see languages.go)
+    includeGenerated bool           // count generated and vendored files in the file type table too
+    cacheDir         string         // where the commit cache is kept, empty with -no-cache (see cache.go)
+    jobs             int            // how many repositories are read at the same time
 }
 
+// The -metric values: what the file type table adds up for every file a commit changed
+const (
+    metricFiles   = "files"   // one per file touched
+    metricAdded   = "added"   // lines added
+    metricDeleted = "deleted" // lines deleted
+    metricChanged = "changed" // lines added plus lines deleted
+)
+
+// The -merges values
+// The commits a merge brings in are walked and counted on their own, so by default
+// a merge adds nothing to the file type table
+const (
+    mergesSkip        = "skip"         // merges count as commits, but not in the file type table
+    mergesFirstParent = "first-parent" // count everything a merge changed compared to its first parent
+)
+
 // type is a Go keyword for declaring new types
 // column is a custom type that's really just a slice of integers
+// It holds one week, indexed by weekday (0 = Sunday), with -1 for days outside the range
 type column []int
 
-func getFileExtension(filename string) string {
-    parts := strings.Split(filename, ".")
-    if len(parts) < 2 {
-        return "no_extension"
-    }
-    return "." + parts[len(parts)-1]
-}
-
-func processFileTypes(commit *object.Commit, fileTypes map[string]int) error {
-    // Get the commit's files
-    files, err := commit.Files()
-    if err != nil {
-        return err
+// processFileTypes adds up the files a commit changed, by extension or language
+// Only the diff against the first parent counts, not every file in the snapshot
+// Parameters:
+//   - files: the files the commit changed, from the commit cache (see cache.go)
+//   - opts: the -metric and -by settings
+//   - generated: the files to leave out, see generated.go
+//   - fileTypes: map of extension or language to running total, updated in place
+func processFileTypes(files []cachedFile, opts statsOptions, generated *generatedRules, fileTypes map[string]int) {
+    for _, file := range files {
+        // Generated and vendored files are left out unless -include-generated
+        if generated.excluded(file.Path) {
+            continue
+        }
+        
+        var n int
+        switch opts.metric {
+        case metricFiles:
+            n = 1
+        case metricAdded:
+            n = file.Added
+        case metricDeleted:
+            n = file.Deleted
+        case metricChanged:
+            n = file.Added + file.Deleted
+        }
+        // A binary file, or a rename with no edits, has no lines to add to the table
+        if n == 0 {
+            continue
+        }
+        // fileTypeKey is defined in languages.go
+        fileTypes[fileTypeKey(file.Path, opts.by)] += n
     }
-
-    // Iterate through changed files
-    files.ForEach(func(file *object.File) error {
-        ext := getFileExtension(file.Name)
-        fileTypes[ext]++
-        return nil
-    })
-
-    return nil
 }
 
 
 // stats is the main entry function for statistics generation
-// Takes an email string parameter to filter commits by author
-func stats(email string) {
+// Takes the config paths (see config.go) and the options saying which commits to count
+// Returns false when some repositories couldn't be read and the report is partial
+func stats(paths configPaths, opts statsOptions) bool {
     // Process all repositories and get commit data
-    commits, fileTypes := processRepositories(email)
+    commits, fileTypes, identities, failures := processRepositories(paths, opts)
+    today := dateOf(time.Now().In(opts.location))
+    // Streaks and totals over the whole range, see summary.go
+    summary := summarize(commits, opts.dateRange, today)
+    if opts.json {
+        printJSONReport(commits, fileTypes, identities, failures, opts, summary)
+        return len(failures) == 0
+    }
     // Print the statistics in a formatted way
-    printCommitsStats(commits)
-	printFileTypeStats(fileTypes)
+    printCommitsStats(commits, opts, today)
+    printSummary(summary, opts.dateRange)
+	printFileTypeStats(fileTypes, opts)
+    // The failures come last, where they are seen
+    printFailures(failures)
+    return len(failures) == 0
 }
 
-// getBeginningOfDay converts a time.Time to the start of that day (00:00:00)
-// time.Time is a type from the time package
-func getBeginningOfDay(t time.Time) time.Time {
-    // Get the date components using the Date() method from time.Time
-    year, month, day := t.Date()
-    
-    // time.Date is a function from time package
-    // Creates a new time.Time at 00:00:00 for the given date
-    startOfDay := time.Date(year, month, day, 0, 0, 0, 0, t.Location())
-    return startOfDay
+// repoHistory is what fillCommits found in one repository, waiting to be merged
+// Repositories are read at the same time, but merged one by one in registry order
+// by mergeHistory, so a commit shared by several clones is counted exactly as a
+// serial run would count it
+type repoHistory struct {
+	identity authorIdentity                   // the email counted in this repository (see identity.go)
+	order    []plumbing.Hash                  // every reachable commit, in the order the walk visited them
+	matched  map[plumbing.Hash]*matchedCommit // the commits by the author inside the range
+	authored bool                             // the author made any commit here, in the range or not
 }
 
-// countDaysSinceDate counts days between a given date and today
-func countDaysSinceDate(date time.Time) int {
-    days := 0
-    // Get start of today using our helper function
-    now := getBeginningOfDay(time.Now())
-    
-    // Loop until we reach today's date
-    for date.Before(now) {
-        // Add 24 hours to our date
-        // time.Hour is a constant from time package
-        date = date.Add(time.Hour * 24)
-        days++
-        
-        // If we're beyond 6 months, return our outOfRange constant
-        if days > daysInLastSixMonths {
-            return outOfRange
-        }
-    }
-    return days
+// matchedCommit is one commit that counts, unless an earlier repository already counted it
+type matchedCommit struct {
+	hash      plumbing.Hash
+	day       civilDate
+	patchID   string         // with -patch-id, see history.go; "" otherwise
+	fileTypes map[string]int // what the commit adds to the file type table
 }
 
-// fillCommits processes a Git repository and counts commits per day and file types
+// fillCommits reads a Git repository and finds the commits to count, by day and file type
+// It only reads from the repository, so several can run at once
 // Parameters:
-//   - email: string to filter commits by author email
-//   - path: string path to the Git repository
-//   - commits: map[int]int to store days-ago -> commit-count mapping
+//   - authors: authorResolver picking the authors to count in this repository (see identity.go)
+//   - r: foundRepo with the repository path and kind (normal, worktree, submodule, bare)
+//   - opts: the range, time zone and refs to look at
 // Returns: 
-//   - map[int]int: the updated commits map
-//   - map[string]int: counts of file types modified
-func fillCommits(email string, path string, commits map[int]int) (map[int]int, map[string]int) {
-	// Create a new map to store file extension counts
-	// map[string]int where key is file extension (e.g., ".go") and value is count
-	fileTypes := make(map[string]int)
- 
-	// git.PlainOpen comes from go-git package
-	// Opens an existing repository at the given path
+//   - *repoHistory: the commits found, to be merged with mergeHistory
+//   - error: why the repository couldn't be read; the other repositories are still counted
+func fillCommits(authors *authorResolver, r foundRepo, opts statsOptions) (*repoHistory, error) {
+	// openRepository is defined in repo.go
+	// It wraps go-git's PlainOpen so worktrees, submodules and bare repositories all open correctly
 	// Returns a *git.Repository and error if any
-	repo, err := git.PlainOpen(path)
+	repo, err := openRepository(r)
 	if err != nil {
-		// panic is a built-in Go function that stops program execution
-		// Used here because we can't continue without repository access
-		panic(err)
+		return nil, fmt.Errorf("can't open repository: %w", err)
 	}
  
-	// repo.Head() gets the HEAD reference of repository
-	// HEAD typically points to the latest commit of current branch
-	// Returns a *plumbing.Reference and error if any
-	ref, err := repo.Head()
+	// The repository's .mailmap maps old names and addresses to current ones
+	// loadRepoMailmap is defined in identity.go
+	repoMailmap := loadRepoMailmap(repo, r)
+	
+	// Without -email, the repository's own user.email is counted if it sets one
+	who, identity := authors.forRepo(repo)
+	
+	// readHistory walks the -refs tips through the commit cache
+	cache, order, err := readHistory(repo, r, opts)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
  
-	// repo.Log gets commit history starting from HEAD
-	// &git.LogOptions{From: ref.Hash()} specifies starting point
-	// ref.Hash() gets the commit hash from the reference
-	// Returns a object.CommitIterator for walking through commits
-	iterator, err := repo.Log(&git.LogOptions{From: ref.Hash()})
-	if err != nil {
-		panic(err)
-	}
- 
-	// Calculate offset for proper calendar alignment
-	// This adjusts commit dates to match GitHub's contribution graph
-	offset := calcOffset()
- 
-	// iterator.ForEach comes from go-git
-	// Walks through each commit in history
-	// Takes a function to process each commit
-	err = iterator.ForEach(func(c *object.Commit) error {
-		// Get number of days between commit date and today
-		// c.Author.When is the commit timestamp
-		daysAgo := countDaysSinceDate(c.Author.When) + offset
- 
-		// Skip if commit author email doesn't match filter
-		// c.Author.Email comes from the commit metadata
-		if c.Author.Email != email {
-			// Return nil to continue to next commit
-			return nil
+	history := &repoHistory{identity: identity, order: order, matched: make(map[plumbing.Hash]*matchedCommit)}
+	var generated *generatedRules
+	for _, hash := range order {
+		c := cache.Commits[hash]
+ 
+		// Skip if the commit author isn't one of the identities we report on
+		if !who.matches(repoMailmap, c.Name, c.Email) {
+			continue
+		}
+		// Remembered even outside the range, to tell a quiet period from a wrong -email
+		history.authored = true
+ 
+		// Get the calendar day of the commit in the display time zone
+		// c.When is the commit timestamp, in the author's own time zone
+		day := dateOf(c.When.In(opts.location))
+		if !opts.dateRange.contains(day) {
+			continue
 		}
+		matched := &matchedCommit{hash: hash, day: day, fileTypes: make(map[string]int)}
  
-		// If commit is within our time range (not outOfRange)
-		if daysAgo != outOfRange {
-			// Increment commit count for that day
-			commits[daysAgo]++
-			
-			// Process file types for this commit
-			// processFileTypes is our helper function that counts file extensions
-			// Pass the current commit and our fileTypes map to update
-			if err := processFileTypes(c, fileTypes); err != nil {
-				// If there's an error processing files, return it
-				// This will stop the commit iteration
-				return err
+		// With -patch-id, a cherry-pick of a counted change isn't counted again
+		// The patch-id is kept in the cache, so it is only worked out once per commit
+		if opts.patchID {
+			if matched.patchID, err = cache.patchID(repo, hash); err != nil {
+				return nil, fmt.Errorf("can't diff commit %s: %w", hash, err)
 			}
 		}
  
-		// Return nil to continue processing commits
-		return nil
-	})
+		// Merges count as commits, but only add to the file type table with -merges first-parent
+		if len(c.Parents) < 2 || opts.merges != mergesSkip {
+			// Only the line metrics need the patch, see commitCache.files
+			files, err := cache.files(repo, hash, opts.metric != metricFiles)
+			if err != nil {
+				return nil, fmt.Errorf("can't diff commit %s: %w", hash, err)
+			}
+			// Generated and vendored files are left out of the file type table unless
+			// -include-generated. The rules are only read from the HEAD tree once a commit
+			// needs them; loadGeneratedRules is defined in generated.go, and a nil rule
+			// set leaves nothing out
+			if generated == nil && !opts.includeGenerated {
+				generated = loadGeneratedRules(repo)
+			}
+			processFileTypes(files, opts, generated, matched.fileTypes)
+		}
+		history.matched[hash] = matched
+	}
  
-	// Check for errors during commit processing
+	saveCache(cache, r)
+	return history, nil
+}
+
+// readHistory finds every commit reachable from the -refs tips of a repository
+// The commit cache knows the author, date and changed files of every commit an
+// earlier run saw, so only commits made since then are read from the repository
+// Returns the cache, to be saved once done with, and the commits in walk order
+func readHistory(repo *git.Repository, r foundRepo, opts statsOptions) (*commitCache, []plumbing.Hash, error) {
+	// startCommits finds the tips of the refs picked with -refs, HEAD by default
+	// It is defined in history.go
+	starts, err := startCommits(repo, opts.refs)
 	if err != nil {
-		panic(err)
+		return nil, nil, fmt.Errorf("can't list refs: %w", err)
 	}
  
-	// Return both the commits map and fileTypes map
-	// This allows the caller to aggregate statistics across repositories
-	return commits, fileTypes
- }
+	// loadCommitCache is defined in cache.go
+	cache := loadCommitCache(opts.cacheDir, r, opts.refs)
+	order, err := cache.update(repo, starts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't read history: %w", err)
+	}
+	return cache, order, nil
+}
+
+// saveCache writes a commit cache back
+// A cache that can't be written only costs time on the next run, so this only warns
+func saveCache(cache *commitCache, r foundRepo) {
+	if err := cache.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: can't save the commit cache for %s: %v\n", r.path, err)
+	}
+}
+
+// mergeHistory adds one repository's commits to the totals
+// A commit already counted from an earlier repository is skipped. With -patch-id,
+// of the copies of one change only the earliest is counted, whatever order the
+// cache lists them in. Only counted commits go into dedup: each repository
+// may count another identity or use another .mailmap, so a commit an earlier clone
+// walked past can still be the author's here
+func mergeHistory(history *repoHistory, dedup *commitDedup, commits map[civilDate]int, fileTypes map[string]int) {
+	for _, hash := range history.order {
+		c, ok := history.matched[hash]
+		if !ok || dedup.hashes[hash] {
+			continue
+		}
+		dedup.hashes[hash] = true
+ 
+		counted, replaced := dedup.countPatch(c)
+		if !counted {
+			continue
+		}
+		if replaced != nil {
+			addCommit(replaced, -1, commits, fileTypes)
+		}
+		addCommit(c, 1, commits, fileTypes)
+	}
+}
+
+// addCommit adds a counted commit to the totals, or takes it back out when sign is -1
+func addCommit(c *matchedCommit, sign int, commits map[civilDate]int, fileTypes map[string]int) {
+	commits[c.day] += sign
+	if commits[c.day] == 0 {
+		delete(commits, c.day)
+	}
+	for key, n := range c.fileTypes {
+		fileTypes[key] += sign * n
+		if fileTypes[key] == 0 {
+			delete(fileTypes, key)
+		}
+	}
+}
+
+// defaultStatsJobs picks how many repositories stats reads at the same time
+// Diffing commits keeps a CPU busy, so there is one per CPU
+func defaultStatsJobs() int {
+	return runtime.NumCPU()
+}
+
+// repoFailure is a repository stats couldn't read, and left out of the report
+type repoFailure struct {
+	Repository string `json:"repository"` // alias, or path when there is none
+	Path       string `json:"path"`
+	Error      string `json:"error"`
+}
+
+// readRepositories runs fillCommits on every repository with at most jobs at a time
+// The results come back in the same order as entries, however the reads interleave;
+// a repository that fails has a nil history and is listed in the failures instead
+func readRepositories(authors *authorResolver, entries []*registryEntry, opts statsOptions) ([]*repoHistory, []repoFailure) {
+	histories := make([]*repoHistory, len(entries))
+	errs := make([]error, len(entries))
+	runJobs(len(entries), opts.jobs, func(i int) {
+		// Each job writes only its own slots, so no lock is needed
+		histories[i], errs[i] = readRepository(authors, entries[i], opts)
+	})
+	return histories, collectFailures(entries, errs)
+}
+
+// readRepository is fillCommits for one registry entry
+func readRepository(authors *authorResolver, entry *registryEntry, opts statsOptions) (history *repoHistory, err error) {
+	defer recoverFailure(&err)
+	// repo() gives the path and kind of the repository, see registry.go
+	return fillCommits(authors, entry.repo(), opts)
+}
+
+// recoverFailure turns a panic into an error, when deferred by a function returning err
+// go-git can panic on a corrupt object store, and one broken repository
+// shouldn't take the whole report down with it
+func recoverFailure(err *error) {
+	if p := recover(); p != nil {
+		*err = fmt.Errorf("unexpected failure: %v", p)
+	}
+}
+
+// runJobs calls fn for every index below count, with at most jobs calls running at a time
+// It returns once every call is done
+func runJobs(count int, jobs int, fn func(i int)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+ 
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// collectFailures lists the entries whose error isn't nil, in the order of entries
+func collectFailures(entries []*registryEntry, errs []error) []repoFailure {
+	var failures []repoFailure
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, repoFailure{
+				Repository: entries[i].displayName(),
+				Path:       entries[i].Path,
+				Error:      err.Error(),
+			})
+		}
+	}
+	return failures
+}
+
+// repoIdentity is the email counted in one repository, for the report
+type repoIdentity struct {
+	Repository string `json:"repository"` // alias, or path when there is none
+	Path       string `json:"path"`
+	authorIdentity
+}
+
+// printRepoIdentities says which email was counted in which repositories
+// Repositories counting the same email are listed together, in registry order
+func printRepoIdentities(identities []repoIdentity) {
+	var keys []string
+	groups := make(map[string][]string)
+	sources := make(map[string]string)
+	for _, id := range identities {
+		key := strings.Join(id.Emails, ", ")
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+			sources[key] = id.Source
+		}
+		groups[key] = append(groups[key], id.Repository)
+	}
+ 
+	for _, key := range keys {
+		if key == "" {
+			fmt.Printf("No user.email in git config, nothing counted in: %s\n", strings.Join(groups[key], ", "))
+			fmt.Println("  Set one with git config --global user.email, or pass -email")
+			continue
+		}
+		fmt.Printf("Counting %s (%s) in: %s\n", key, sources[key], strings.Join(groups[key], ", "))
+	}
+	fmt.Println()
+}
+
+// printFailures lists the repositories left out of the report and why
+func printFailures(failures []repoFailure) {
+	if len(failures) == 0 {
+		return
+	}
+ 
+	noun := "repositories"
+	if len(failures) == 1 {
+		noun = "repository"
+	}
+	fmt.Printf("Couldn't read %d %s, so this report is incomplete:\n", len(failures), noun)
+	for _, f := range failures {
+		fmt.Printf("  %s: %s\n", f.Repository, f.Error)
+	}
+	fmt.Println()
+}
  
  // processRepositories scans all repositories and processes commit data
- // Parameter:
- //   - email: string to filter commits by author
+ // Parameters:
+ //   - paths: where the registry lives, see config.go
+ //   - opts: the author to count and which repositories to look at
  // Returns: 
- //   - map[int]int: days-ago to commit count mapping
+ //   - map[civilDate]int: date to commit count mapping
  //   - []FileTypeStats: sorted slice of file extension statistics
- func processRepositories(email string) (map[int]int, []FileTypeStats) {
-	// Get path to our repository list file
-	// getDotFilePath() is defined in scan.go
-	filePath := getDotFilePath()
+ //   - []repoIdentity: the email counted in each repository that could be read
+ //   - []repoFailure: the repositories that couldn't be read, in registry order
+ func processRepositories(paths configPaths, opts statsOptions) (map[civilDate]int, []FileTypeStats, []repoIdentity, []repoFailure) {
+	// Load the list of registered repositories
+	// mustLoadRegistry() is defined in registry.go
+	reg := mustLoadRegistry(paths)
+	
+	// The global identities file maps a person's other addresses to one canonical address
+	// loadIdentities and newAuthorResolver are defined in identity.go
+	identities, err := loadIdentities(paths)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Without -email, the author comes from the git config
+	authors := newAuthorResolver(opts.emails, identities)
+	
+	// Keep only the repositories matching the -tag filter
+	// filterEntries is defined in tags.go and returns everything for a nil filter
+	repos := filterEntries(reg.Repos, opts.filter)
+	// The header would break -json output, which has to be JSON only
+	if opts.filter != nil && !opts.json {
+		fmt.Printf("Showing %d of %d repositories matching %s\n\n", len(repos), len(reg.Repos), opts.filterText)
+	}
 	
-	// Read repository paths from file
-	// parseFileLinesToSlice() is defined in scan.go
-	repos := parseFileLinesToSlice(filePath)
+	// Clones and forks of one project are walked together, see groupProjects in project.go
+	// Their shared commits are counted once through dedup below
+	resolveIdentities(paths, repos, opts.cacheDir, opts.jobs)
+	projects := groupProjects(repos)
+	if len(projects) < len(repos) && !opts.json {
+		fmt.Printf("Counting %d repositories as %d projects\n\n", len(repos), len(projects))
+	}
  
 	// Store number of days we're tracking
-	daysInMap := daysInLastSixMonths
+	daysInMap := opts.dateRange.days()
  
 	// Create map for commit counts
 	// make is a built-in Go function to create maps
-	commits := make(map[int]int, daysInMap)
+	commits := make(map[civilDate]int, daysInMap)
 	
 	// Create map for aggregating file type counts across all repositories
 	// Key is file extension, value is total count
 	allFileTypes := make(map[string]int)
+	
+	// One set of already-walked commits for every repository, so a commit on
+	// several branches or in several clones is counted once
+	dedup := newCommitDedup(opts.patchID)
  
 	// Initialize all days with zero commits
-	// Using reverse loop: daysInMap down to 1
-	for i := daysInMap; i > 0; i-- {
-		commits[i] = 0
-	}
- 
-	// Process each repository in our list
-	// range is a Go keyword for iterating over slices
-	for _, path := range repos {
-		// Process this repository and get its statistics
-		// newCommits: updated commit counts
-		// newFileTypes: file type counts from this repo
-		newCommits, newFileTypes := fillCommits(email, path, commits)
-		
-		// Update our commits map with results from this repo
-		commits = newCommits
-		
-		// Merge file type counts from this repo into our total counts
-		// range over map of new file types
-		for ext, count := range newFileTypes {
-			// Add counts to our running totals
-			allFileTypes[ext] += count
+	for d := opts.dateRange.start; !d.after(opts.dateRange.end); d = d.addDays(1) {
+		commits[d] = 0
+	}
+ 
+	// Read every repository of every project, -jobs at a time
+	var order []*registryEntry
+	for _, project := range projects {
+		order = append(order, project.entries...)
+	}
+	histories, failures := readRepositories(authors, order, opts)
+	
+	// Say which email was counted where; with -email it is the same everywhere
+	var matched []repoIdentity
+	for i, history := range histories {
+		if history != nil {
+			matched = append(matched, repoIdentity{
+				Repository:     order[i].displayName(),
+				Path:           order[i].Path,
+				authorIdentity: history.identity,
+			})
+		}
+	}
+	if !authors.explicit && !opts.json {
+		printRepoIdentities(matched)
+	}
+ 
+	// Merge in registry order, so the totals are the same however the reads interleaved
+	// Failed repositories have no history and add nothing
+	for _, history := range histories {
+		if history != nil {
+			mergeHistory(history, dedup, commits, allFileTypes)
 		}
 	}
+	
+	// An author with no commits at all, in any repository, is most likely a wrong
+	// or old email; offer the closest authors seen instead (see authors.go)
+	authored := false
+	var asked []string
+	for _, history := range histories {
+		if history == nil {
+			continue
+		}
+		authored = authored || history.authored
+		for _, email := range history.identity.Emails {
+			if !containsString(asked, email) {
+				asked = append(asked, email)
+			}
+		}
+	}
+	if !authored && len(asked) > 0 {
+		all, _ := collectAuthors(order, opts)
+		// -json output has to stay JSON only, so the hint goes to standard error there
+		var w io.Writer = os.Stdout
+		if opts.json {
+			w = os.Stderr
+		}
+		printSuggestions(w, asked, suggestAuthors(asked, all))
+	}
  
 	// Create slice to hold sorted file type statistics
 	var fileTypeStats []FileTypeStats
@@ -254,44 +562,27 @@ func fillCommits(email string, path string, commits map[int]int) (map[int]int, m
 	// Sorts our slice of FileTypeStats based on Count field
 	// The function provided returns true if element i should come before element j
 	sort.Slice(fileTypeStats, func(i, j int) bool {
-		// Sort in descending order (higher counts first)
-		return fileTypeStats[i].Count > fileTypeStats[j].Count
+		// Sort in descending order (higher counts first), ties by name so the order is stable
+		if fileTypeStats[i].Count != fileTypeStats[j].Count {
+			return fileTypeStats[i].Count > fileTypeStats[j].Count
+		}
+		return fileTypeStats[i].Name < fileTypeStats[j].Name
 	})
  
 	// Return both the commit counts and sorted file type statistics
-	return commits, fileTypeStats
+	return commits, fileTypeStats, matched, failures
  }
 
-// calcOffset determines how many days to offset for calendar alignment
-// Returns: int representing number of days to offset
-func calcOffset() int {
-    // Declare offset variable we'll calculate
-    var offset int
-
-    // time.Now() gets current time
-    // Weekday() returns the day of the week (time.Sunday, time.Monday, etc.)
-    weekday := time.Now().Weekday()
-
-    // switch is a Go keyword for control flow
-    // Similar to if-else but cleaner for multiple cases
-    switch weekday {
-    case time.Sunday:    // time.Sunday is a constant from time package
-        offset = 7
-    case time.Monday:
-        offset = 6
-    case time.Tuesday:
-        offset = 5
-    case time.Wednesday:
-        offset = 4
-    case time.Thursday:
-        offset = 3
-    case time.Friday:
-        offset = 2
-    case time.Saturday:
-        offset = 1
-    }
+// weekStart returns the Sunday starting the week a date falls in
+// Weeks run Sunday to Saturday, like the columns of GitHub's graph
+func weekStart(d civilDate) civilDate {
+    // weekday() returns time.Sunday (0) to time.Saturday (6)
+    return d.addDays(-int(d.weekday()))
+}
 
-    return offset
+// countWeeks returns how many columns the heatmap needs for a range
+func countWeeks(rng dateRange) int {
+    return weekStart(rng.start).daysUntil(weekStart(rng.end))/7 + 1
 }
 
 // printCell formats and prints a single cell of the commit calendar
@@ -346,76 +637,80 @@ func printCell(val int, today bool) {
  }
  
  // printCommitsStats prints the full commit calendar visualization
- // Parameter:
- //   - commits: map[int]int where key is days-ago and value is commit count
- func printCommitsStats(commits map[int]int) {
-	// Get sorted list of day indices
-	keys := sortMapIntoSlice(commits)
+ // With -year or -years there is one calendar per year, stacked newest first
+ // Parameters:
+ //   - commits: map[civilDate]int where key is the date and value is commit count
+ //   - opts: the range or years to show
+ //   - today: the current date in the display time zone, highlighted when shown
+ func printCommitsStats(commits map[civilDate]int, opts statsOptions, today civilDate) {
+	if len(opts.years) == 0 {
+		printCalendar(commits, opts.dateRange, today, "from "+opts.dateRange.String())
+		return
+	}
+	for i, year := range opts.years {
+		if i > 0 {
+			fmt.Printf("\n")
+		}
+		printCalendar(commits, yearRange(year), today, fmt.Sprintf("in %d", year))
+	}
+ }
+ 
+ // printCalendar prints one heatmap with its total-contributions line above it,
+ // e.g. "1,234 contributions in 2025" like GitHub's profile
+ func printCalendar(commits map[civilDate]int, rng dateRange, today civilDate, title string) {
+	// Days after today aren't drawn, so they aren't counted either
+	total := 0
+	for day, count := range commits {
+		if rng.contains(day) && !day.after(today) {
+			total += count
+		}
+	}
+	noun := "contributions"
+	if total == 1 {
+		noun = "contribution"
+	}
+	fmt.Printf("%s %s %s\n\n", formatCount(total), noun, title)
 	// Organize commits into columns (weeks)
-	cols := buildCols(keys, commits)
+	cols := buildCols(commits, rng, today)
 	// Print the formatted calendar
-	printCells(cols)
+	printCells(cols, rng, today)
  }
  
- // sortMapIntoSlice converts map keys to sorted slice
- // Parameter:
- //   - m: map[int]int to get keys from
- // Returns: []int slice of sorted keys
- func sortMapIntoSlice(m map[int]int) []int {
-	// Create slice to hold keys
-	var keys []int
-	
-	// range over map to extract keys
-	// _ ignores the values, we only want keys
-	for k := range m {
-		keys = append(keys, k)
+ // formatCount writes a number with thousands separators, e.g. 1,234
+ func formatCount(n int) string {
+	s := strconv.Itoa(n)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
 	}
-	
-	// sort.Ints comes from sort package
-	// Sorts slice of integers in ascending order
-	sort.Ints(keys)
- 
-	return keys
+	return s
  }
  
  // buildCols organizes commits into a column-based structure
  // Parameters:
- //   - keys: []int slice of sorted day indices
- //   - commits: map[int]int of commit counts
- // Returns: map[int]column where key is week number
- func buildCols(keys []int, commits map[int]int) map[int]column {
-	// Create map to store columns
+ //   - commits: map[civilDate]int of commit counts
+ //   - rng: dateRange the calendar covers
+ //   - today: days after it are left blank, like GitHub does for the current year
+ // Returns: []column with one column per week, oldest first
+ func buildCols(commits map[civilDate]int, rng dateRange, today civilDate) []column {
 	// Each column represents a week
-	cols := make(map[int]column)
+	cols := make([]column, countWeeks(rng))
 	
-	// Create empty column slice
-	// column is our custom type defined at top
-	col := column{}
- 
-	// Iterate through sorted days
-	for _, k := range keys {
-		// Calculate week number (0-26)
-		// Integer division by 7 gives week number
-		week := int(k / 7)
-		
-		// Calculate day within week (0-6)
-		// Modulo 7 gives day of week
-		dayinweek := k % 7
- 
-		// If it's start of week (Sunday)
-		// Reset column to empty
-		if dayinweek == 0 {
-			col = column{}
-		}
- 
-		// Add this day's commit count to current column
-		col = append(col, commits[k])
- 
-		// If it's end of week (Saturday)
-		// Save completed column to map
-		if dayinweek == 6 {
-			cols[week] = col
+	// Walk the weeks from the Sunday before the start of the range
+	sunday := weekStart(rng.start)
+	for i := range cols {
+		// column is our custom type defined at top
+		col := make(column, 7)
+		for weekday := 0; weekday < 7; weekday++ {
+			day := sunday.addDays(weekday)
+			if !rng.contains(day) || day.after(today) {
+				// Padding before the start or after the end of the range, and days still to come
+				col[weekday] = -1
+				continue
+			}
+			col[weekday] = commits[day]
 		}
+		cols[i] = col
+		sunday = sunday.addDays(7)
 	}
  
 	return cols
@@ -423,43 +718,30 @@ func printCell(val int, today bool) {
 
  // printCells prints the entire commit calendar visualization
 // Parameters:
-//   - cols: map[int]column containing organized commit data by weeks
-func printCells(cols map[int]column) {
+//   - cols: []column containing organized commit data by weeks, oldest first
+//   - rng: dateRange the calendar covers
+//   - today: the date to highlight
+func printCells(cols []column, rng dateRange, today civilDate) {
 	// First print the month names row at top of calendar
-	printMonths()
+	printMonths(rng)
+	
+	first := weekStart(rng.start)
 	
 	// Iterate through days of week (top to bottom)
-	// 6 to 0 represents Saturday to Sunday
-	for j := 6; j >= 0; j-- {
-		// Iterate through weeks (right to left)
-		// weeksInLastSixMonths+1 to 0 for all weeks plus current
-		for i := weeksInLastSixMonths + 1; i >= 0; i-- {
-			// If we're at the start of a row
-			// Print the day name (Mon, Wed, etc.)
-			if i == weeksInLastSixMonths+1 {
-				printDayCol(j)
-			}
-			
-			// Check if we have data for this week
-			// ok is a bool that's true if key exists in map
-			if col, ok := cols[i]; ok {
-				// Check if this cell represents today
-				// Uses calcOffset() to align with GitHub's display
-				if i == 0 && j == calcOffset()-1 {
-					// Print cell with today's formatting
-					printCell(col[j], true)
-					continue
-				} else {
-					// If we have data for this day
-					if len(col) > j {
-						// Print regular cell
-						printCell(col[j], false)
-						continue
-					}
-				}
+	// 0 to 6 represents Sunday to Saturday, the way GitHub shows them
+	for j := 0; j < 7; j++ {
+		// Print the day name (Mon, Wed, etc.) at the start of the row
+		printDayCol(j)
+		
+		// Iterate through weeks, oldest on the left
+		for i, col := range cols {
+			val := col[j]
+			if val < 0 {
+				// Days outside the range are left blank
+				fmt.Printf("    ")
+				continue
 			}
-			// If no data exists, print empty cell
-			printCell(0, false)
+			printCell(val, first.addDays(7*i+j) == today)
 		}
 		// Print newline at end of each row
 		// fmt.Printf comes from fmt package
@@ -468,43 +750,42 @@ func printCells(cols map[int]column) {
  }
  
  // printMonths prints the month labels at top of calendar
- // Uses no parameters as it calculates based on current date
- func printMonths() {
-	// Calculate start date (6 months ago)
-	// time.Now() gets current time
-	// getBeginningOfDay converts to start of day
-	// Subtract days to get to start date
-	week := getBeginningOfDay(time.Now()).Add(-(daysInLastSixMonths * time.Hour * 24))
+ // A label goes above the first week of each month, so its width matches the cells below
+ // Parameter:
+ //   - rng: dateRange the calendar covers
+ func printMonths(rng dateRange) {
+	// Sunday of the oldest week
+	week := weekStart(rng.start)
 	
-	// Get initial month to track changes
-	// Month() returns time.Month type
-	month := week.Month()
+	// Print initial spacing to skip the day name column
+	fmt.Printf("     ")
 	
-	// Print initial spacing for alignment
-	fmt.Printf("         ")
+	// Track the month of the previous column, 0 means no column printed yet
+	var month time.Month
 	
-	// Loop through weeks until we reach current date
-	for {
+	// Loop through every week of the calendar
+	for i := 0; i < countWeeks(rng); i++ {
+		// The first column may start before the range, label the month of its first real day
+		first := week
+		if first.before(rng.start) {
+			first = rng.start
+		}
+		
 		// If month has changed
-		if week.Month() != month {
+		if first.month != month {
 			// Print abbreviated month name (e.g., "Jan")
 			// String() converts month to string
 			// [:3] takes first 3 characters
-			fmt.Printf("%s ", week.Month().String()[:3])
+			fmt.Printf("%s ", first.month.String()[:3])
 			// Update tracking month
-			month = week.Month()
+			month = first.month
 		} else {
 			// Print spaces for weeks within same month
 			fmt.Printf("    ")
 		}
  
-		// Add 7 days to move to next week
-		week = week.Add(7 * time.Hour * 24)
-		
-		// If we've passed current date, exit loop
-		if week.After(time.Now()) {
-			break
-		}
+		// Move to the next week
+		week = week.addDays(7)
 	}
 	
 	// Print newline after month row
@@ -533,8 +814,24 @@ func printCells(cols map[int]column) {
 	fmt.Printf(out)
  }
 
- func printFileTypeStats(stats []FileTypeStats) {
-    fmt.Printf("\nFile Type Statistics:\n")
+ // printFileTypeStats prints the ten file types with the highest counts
+ // opts.metric says what was counted, so the unit after each number matches it
+ func printFileTypeStats(stats []FileTypeStats, opts statsOptions) {
+    unit := "files"
+    switch opts.metric {
+    case metricAdded:
+        unit = "lines added"
+    case metricDeleted:
+        unit = "lines deleted"
+    case metricChanged:
+        unit = "lines changed"
+    }
+    
+    if opts.by == byLanguage {
+        fmt.Printf("\nLanguage Statistics:\n")
+    } else {
+        fmt.Printf("\nFile Type Statistics:\n")
+    }
     fmt.Printf("===================\n")
     
     // Print top 10 or all if less than 10
@@ -545,85 +842,112 @@ func printCells(cols map[int]column) {
     
     for i := 0; i < limit; i++ {
         stat := stats[i]
-        fmt.Printf("%-15s %5d files\n", stat.Extension, stat.Count)
+        fmt.Printf("%-20s %5d %s\n", stat.Name, stat.Count, unit)
     }
     fmt.Println()
 }
 
  /*
- stats(email)
+ stats(paths, opts)
     │
-    ├──► processRepositories(email)
+    ├──► processRepositories(paths, opts)
     │       │
-    │       ├──► Gets repo list from ~/.gogitlocalstats
+    │       ├──► Gets repo list from the registry (registry.go)
+    │       ├──► Keeps repos matching the -tag filter (tags.go)
+    │       ├──► Groups clones of one project (project.go)
     │       │
-    │       ├──► For each repository:
-    │       │    └──► fillCommits(email, path, commits)
+    │       ├──► readRepositories: each repository of each project, -jobs at a time
+    │       │    └──► fillCommits(authors, repo, opts)
     │       │           │
     │       │           ├──► Opens Git repo
-    │       │           ├──► Gets commit history
-    │       │           └──► Counts commits per day
+    │       │           ├──► Brings the commit cache up to date from the -refs tips (cache.go)
+    │       │           └──► Finds the author's commits and their days
+    │       │
+    │       ├──► mergeHistory, in registry order: counts each commit once per day
     │       │
-    │       └──► Returns map[days_ago]commit_count
+    │       └──► Returns map[date]commit_count
+    │
+    ├──► summarize (streaks and totals, summary.go)
     │
-    └──► printCommitsStats(commits)
+    └──► printCommitsStats(commits, opts, today), then printSummary
             │
-            ├──► sortMapIntoSlice (orders days)
-            ├──► buildCols (organizes into weeks)
-            └──► printCells
+            └──► printCalendar (once per -years year)
                   │
-                  ├──► printMonths (top row)
-                  ├──► printDayCol (left column)
-                  └──► printCell (commit data)
+                  ├──► total contributions line
+                  ├──► buildCols (organizes into weeks, blank outside the range)
+                  └──► printCells
+                        │
+                        ├──► printMonths (top row)
+                        ├──► printDayCol (left column)
+                        └──► printCell (commit data)
  */
 
  //With file extension stats
  /*
- stats(email)
+ stats(paths, opts)
     │
-    ├──► processRepositories(email)
+    ├──► processRepositories(paths, opts)
     │       │
-    │       ├──► Gets repo list from ~/.gogitlocalstats
+    │       ├──► Gets repo list from the registry (registry.go)
+    │       ├──► Keeps repos matching the -tag filter (tags.go)
     │       │
     │       ├──► Initialize maps for:
-    │       │    - commits (days → count)
+    │       │    - commits (date → count)
     │       │    - allFileTypes (extension → count)
     │       │
-    │       ├──► For each repository:
-    │       │    └──► fillCommits(email, path, commits)
+    │       ├──► Groups clones of one project (project.go)
+    │       │
+    │       ├──► readRepositories: each repository of each project, -jobs at a time
+    │       │    └──► fillCommits(authors, repo, opts)
     │       │           │
     │       │           ├──► Opens Git repo
-    │       │           ├──► Gets commit history
-    │       │           ├──► For each commit:
-    │       │           │    ├──► Count commits per day
-    │       │           │    └──► processFileTypes:
-    │       │           │         ├──► Get changed files
+    │       │           ├──► Picks the email: -email, else repo user.email, else global (identity.go)
+    │       │           ├──► Brings the commit cache up to date from the -refs tips (cache.go)
+    │       │           │    └──► only commits new since the last run are read
+    │       │           ├──► For each commit by the author inside -since/-until:
+    │       │           │    ├──► Patch-id with -patch-id
+    │       │           │    └──► processFileTypes (merges skipped by default):
+    │       │           │         ├──► Files changed since the first parent, cached after first use
+    │       │           │         ├──► Drop generated/vendored files (.gitattributes, generated.go)
     │       │           │         ├──► Extract extensions
-    │       │           │         └──► Update file type counts
+    │       │           │         └──► Add files or lines (-metric) per extension or language (-by)
     │       │           │
-    │       │           └──► Returns: 
-    │       │                ├──► commits map
-    │       │                └──► fileTypes map
+    │       │           ├──► Saves the commit cache
+    │       │           └──► Returns repoHistory (walk order + matched commits), or an error
+    │       │                that puts the repository on the failure list
+    │       │
+    │       ├──► mergeHistory, in registry order:
+    │       │    ├──► Skips commits already counted (hash, or patch-id)
+    │       │    └──► Adds the rest to commits and allFileTypes
+    │       │
+    │       ├──► No commit by the author anywhere: printSuggestions (authors.go)
     │       │
     │       └──► Post-process file stats:
     │           ├──► Convert to FileTypeStats slice
     │           └──► Sort by frequency
     │
-    ├──► printCommitsStats(commits)
+    ├──► summarize (streaks and totals, summary.go)
+    │       └──► with -json: printJSONReport and stop
+    │
+    ├──► printCommitsStats(commits, opts, today), then printSummary
     │       │
-    │       ├──► sortMapIntoSlice (orders days)
-    │       ├──► buildCols (organizes into weeks)
-    │       └──► printCells
+    │       └──► printCalendar (once per -years year)
     │             │
-    │             ├──► printMonths (top row)
-    │             ├──► printDayCol (left column)
-    │             └──► printCell (commit data)
+    │             ├──► total contributions line
+    │             ├──► buildCols (organizes into weeks, blank outside the range)
+    │             └──► printCells
+    │                   │
+    │                   ├──► printMonths (top row)
+    │                   ├──► printDayCol (left column)
+    │                   └──► printCell (commit data)
     │
-    └──► printFileTypeStats(fileTypeStats)
-            │
-            └──► Displays top 10 file types:
-                 Extension    Count
-                 .go         123
-                 .js          89
-                 etc...
+    ├──► printFileTypeStats(fileTypeStats, opts)
+    │       │
+    │       └──► Displays top 10 file types or languages:
+    │            Name         Count
+    │            .go         123
+    │            .js          89
+    │            etc...
+    │
+    └──► printFailures (repositories that couldn't be read; main exits with exitPartial)
  */
\ No newline at end of file
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// streak is a run of consecutive days with at least one contribution
+type streak struct {
+	start civilDate
+	end   civilDate
+	days  int
+}
+
+// MarshalJSON writes a streak with its dates as strings, e.g. {"days":3,"start":"2025-03-12",...}
+// A zero-length streak has no dates
+func (s streak) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Days  int    `json:"days"`
+		Start string `json:"start,omitempty"`
+		End   string `json:"end,omitempty"`
+	}{Days: s.days}
+	if s.days > 0 {
+		out.Start = s.start.String()
+		out.End = s.end.String()
+	}
+	return json.Marshal(out)
+}
+
+// contributionSummary is the line-up of numbers printed under the heatmap
+type contributionSummary struct {
+	Total           int     `json:"total"`
+	ActiveDays      int     `json:"active_days"`
+	AveragePerDay   float64 `json:"average_per_active_day"`
+	CurrentStreak   streak  `json:"current_streak"`
+	LongestStreak   streak  `json:"longest_streak"`
+	BusiestDay      string  `json:"busiest_day,omitempty"`
+	BusiestDayCount int     `json:"busiest_day_commits"`
+}
+
+// summarize works out totals and streaks from the per-day counts of a range
+// The current streak ends today, or yesterday while today has nothing yet, so a streak
+// isn't broken before the day is over; it is zero when the range ends before that
+// Days after today are left out, as they are from the heatmap, so a range running into
+// the future neither breaks the streak nor counts commits dated ahead of the clock
+func summarize(commits map[civilDate]int, rng dateRange, today civilDate) contributionSummary {
+	var sum contributionSummary
+	var run streak
+	yesterday := today.addDays(-1)
+	last := rng.end
+	if last.after(today) {
+		last = today
+	}
+
+	for d := rng.start; !d.after(last); d = d.addDays(1) {
+		count := commits[d]
+		if count == 0 {
+			run = streak{}
+			continue
+		}
+
+		sum.Total += count
+		sum.ActiveDays++
+		if count > sum.BusiestDayCount {
+			sum.BusiestDay = d.String()
+			sum.BusiestDayCount = count
+		}
+
+		if run.days == 0 {
+			run.start = d
+		}
+		run.end = d
+		run.days++
+		if run.days > sum.LongestStreak.days {
+			sum.LongestStreak = run
+		}
+		// The run is remembered as it reaches today, before the empty days after it reset it
+		if d == today || (d == yesterday && commits[today] == 0) {
+			sum.CurrentStreak = run
+		}
+	}
+
+	if sum.ActiveDays > 0 {
+		sum.AveragePerDay = float64(sum.Total) / float64(sum.ActiveDays)
+	}
+
+	return sum
+}
+
+// printSummary prints the summary lines under the heatmap
+func printSummary(sum contributionSummary, rng dateRange) {
+	fmt.Printf("\nContributions:   %s from %s\n", formatCount(sum.Total), rng)
+	fmt.Printf("Current streak:  %s\n", formatStreak(sum.CurrentStreak))
+	fmt.Printf("Longest streak:  %s\n", formatStreak(sum.LongestStreak))
+	if sum.ActiveDays == 0 {
+		return
+	}
+	fmt.Printf("Busiest day:     %s (%d commits)\n", sum.BusiestDay, sum.BusiestDayCount)
+	fmt.Printf("Average:         %.1f commits per active day (%d active days)\n", sum.AveragePerDay, sum.ActiveDays)
+}
+
+// formatStreak describes a streak, e.g. "3 days (2025-03-12 to 2025-03-14)"
+func formatStreak(s streak) string {
+	switch s.days {
+	case 0:
+		return "0 days"
+	case 1:
+		return fmt.Sprintf("1 day (%s)", s.start)
+	}
+	return fmt.Sprintf("%d days (%s to %s)", s.days, s.start, s.end)
+}
+
+// dayCount is one day of the -json export
+type dayCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// statsReport is what -json writes instead of the heatmap
+type statsReport struct {
+	From       string              `json:"from"`
+	To         string              `json:"to"`
+	Summary    contributionSummary `json:"summary"`
+	Days       []dayCount          `json:"days"`
+	Metric     string              `json:"file_type_metric"`
+	By         string              `json:"file_type_grouping"`
+	FileTypes  []FileTypeStats     `json:"file_types"`
+	Identities []repoIdentity      `json:"identities"`
+	Failures   []repoFailure       `json:"failed_repositories"`
+}
+
+// printJSONReport writes the day counts, summary and file types as indented JSON on stdout
+// opts gives the -metric and -by the file type counts were made with; identities are the
+// emails counted in each repository, and failures the repositories left out, so a script
+// can tell a partial report from a complete one
+func printJSONReport(commits map[civilDate]int, fileTypes []FileTypeStats, identities []repoIdentity, failures []repoFailure, opts statsOptions, sum contributionSummary) {
+	rng := opts.dateRange
+	report := statsReport{
+		From:       rng.start.String(),
+		To:         rng.end.String(),
+		Summary:    sum,
+		Days:       []dayCount{},
+		Metric:     opts.metric,
+		By:         opts.by,
+		FileTypes:  fileTypes,
+		Identities: identities,
+		Failures:   failures,
+	}
+	for d := rng.start; !d.after(rng.end); d = d.addDays(1) {
+		report.Days = append(report.Days, dayCount{Date: d.String(), Count: commits[d]})
+	}
+	if report.FileTypes == nil {
+		report.FileTypes = []FileTypeStats{}
+	}
+	if report.Identities == nil {
+		report.Identities = []repoIdentity{}
+	}
+	if report.Failures == nil {
+		report.Failures = []repoFailure{}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+}
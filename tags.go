@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// isTagChar reports whether r may appear in a tag name
+// Tags look like "work", "oss" or "client-x"; the characters used by
+// filter expressions ( ! & | , and spaces ) are never part of a name
+func isTagChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_./:", r)
+}
+
+// normalizeTag checks a tag name and returns it in lower case, so "Work" and "work" are the same tag
+func normalizeTag(tag string) (string, error) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return "", fmt.Errorf("empty tag name")
+	}
+	for _, r := range tag {
+		if !isTagChar(r) {
+			return "", fmt.Errorf("invalid tag %q: only letters, digits and - _ . / : are allowed", tag)
+		}
+	}
+	return strings.ToLower(tag), nil
+}
+
+// normalizeTags runs normalizeTag over a list of tags
+func normalizeTags(tags []string) ([]string, error) {
+	var normalized []string
+	for _, tag := range tags {
+		t, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, t)
+	}
+	return normalized, nil
+}
+
+// addTags adds tags to an entry, keeping its tag list sorted and free of duplicates
+func (e *registryEntry) addTags(tags []string) {
+	for _, tag := range tags {
+		if !e.hasTag(tag) {
+			e.Tags = append(e.Tags, tag)
+		}
+	}
+	sort.Strings(e.Tags)
+}
+
+// removeTags removes tags from an entry
+func (e *registryEntry) removeTags(tags []string) {
+	var kept []string
+	for _, tag := range e.Tags {
+		drop := false
+		for _, t := range tags {
+			if t == tag {
+				drop = true
+			}
+		}
+		if !drop {
+			kept = append(kept, tag)
+		}
+	}
+	e.Tags = kept
+}
+
+// hasTag reports whether an entry carries a tag
+func (e *registryEntry) hasTag(tag string) bool {
+	for _, t := range e.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// tagExpr is a parsed tag filter such as "work && !client-x"
+type tagExpr interface {
+	match(e *registryEntry) bool
+}
+
+// tagName matches entries carrying one tag
+type tagName string
+
+// notExpr matches entries its operand doesn't match
+type notExpr struct{ operand tagExpr }
+
+// andExpr matches entries both sides match
+type andExpr struct{ left, right tagExpr }
+
+// orExpr matches entries either side matches
+type orExpr struct{ left, right tagExpr }
+
+func (t tagName) match(e *registryEntry) bool { return e.hasTag(string(t)) }
+func (n notExpr) match(e *registryEntry) bool { return !n.operand.match(e) }
+func (a andExpr) match(e *registryEntry) bool { return a.left.match(e) && a.right.match(e) }
+func (o orExpr) match(e *registryEntry) bool  { return o.left.match(e) || o.right.match(e) }
+
+// parseTagFilters combines the -tag flags given to stats or -list
+// Every flag is an expression, and a repository has to match all of them
+// No flags at all gives nil, which filterEntries treats as "everything"
+func parseTagFilters(filters []string) (tagExpr, error) {
+	var combined tagExpr
+	for _, filter := range filters {
+		expr, err := parseTagExpr(filter)
+		if err != nil {
+			return nil, err
+		}
+		if combined == nil {
+			combined = expr
+		} else {
+			combined = andExpr{combined, expr}
+		}
+	}
+	return combined, nil
+}
+
+// filterEntries returns the entries matching filter, in registry order
+func filterEntries(entries []*registryEntry, filter tagExpr) []*registryEntry {
+	if filter == nil {
+		return entries
+	}
+	var matched []*registryEntry
+	for _, e := range entries {
+		if filter.match(e) {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// parseTagExpr parses a boolean tag expression
+// The grammar, from lowest to highest precedence:
+//
+//	or   := and { ("||" | "," | "or") and }
+//	and  := not { ("&&" | "and") not }
+//	not  := ("!" | "not") not | "(" or ")" | tag
+//
+// e.g. "work", "oss || side", "work && !client-x", "(work or oss) and not archived"
+func parseTagExpr(input string) (tagExpr, error) {
+	tokens, err := tokenizeTagExpr(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &tagParser{tokens: tokens}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("tag filter %q: %w", input, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("tag filter %q: unexpected %q", input, p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+// tokenizeTagExpr splits an expression into operators, parentheses and tag names
+func tokenizeTagExpr(input string) ([]string, error) {
+	var tokens []string
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == '!' || r == ',':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '&' || r == '|':
+			// Accept both "&&" and a single "&", and the same for "|"
+			if i+1 < len(runes) && runes[i+1] == r {
+				i++
+			}
+			tokens = append(tokens, string([]rune{r, r}))
+			i++
+		case isTagChar(r):
+			start := i
+			for i < len(runes) && isTagChar(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		default:
+			return nil, fmt.Errorf("tag filter %q: unexpected character %q", input, r)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty tag filter")
+	}
+	return tokens, nil
+}
+
+// tagParser is a small recursive-descent parser over the tokens of a tag expression
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+// peek returns the next token without consuming it, or "" at the end
+func (p *tagParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// accept consumes the next token if it is one of the given alternatives
+// Word operators like "and" are matched case-insensitively
+func (p *tagParser) accept(alternatives ...string) bool {
+	next := strings.ToLower(p.peek())
+	for _, alt := range alternatives {
+		if next == alt {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *tagParser) parseOr() (tagExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||", ",", "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *tagParser) parseAnd() (tagExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&", "and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *tagParser) parseNot() (tagExpr, error) {
+	if p.accept("!", "not") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{operand}, nil
+	}
+
+	if p.accept("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return expr, nil
+	}
+
+	token := p.peek()
+	if token == "" {
+		return nil, fmt.Errorf("expression ends too early")
+	}
+	tag, err := normalizeTag(token)
+	if err != nil {
+		return nil, fmt.Errorf("expected a tag, got %q", token)
+	}
+	p.pos++
+	return tagName(tag), nil
+}
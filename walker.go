@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// walkResult is everything a directory walk produced
+type walkResult struct {
+	repos   []foundRepo   // repositories found, sorted by path
+	visited int           // number of directories that were read
+	elapsed time.Duration // wall-clock time the walk took
+	skipped []scanError   // paths that could not be read, sorted by path
+}
+
+// scanError is a path the walker had to skip and the reason why
+type scanError struct {
+	path string
+	err  error
+}
+
+// reason returns the error without the path, which is printed separately
+// e.g. "permission denied" instead of "open /root/x: permission denied"
+func (e scanError) reason() string {
+	var pathErr *os.PathError
+	// errors.As finds a *os.PathError anywhere in the error chain
+	if errors.As(e.err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	return e.err.Error()
+}
+
+// defaultScanWorkers picks how many directories are read at the same time
+// Reading directories is mostly waiting on the disk, so we go a bit past the CPU count
+func defaultScanWorkers() int {
+	return runtime.NumCPU() * 2
+}
+
+// fileID identifies a directory by device and inode, see fileIdentity in fileid_unix.go
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// dirJob is one directory waiting to be read
+type dirJob struct {
+	path string   // full path of the directory
+	rel  []string // path components below the scan root, used to match exclusion rules
+}
+
+// dirQueue is the shared list of directories still waiting to be read
+// pending counts directories that are queued or currently being read,
+// so workers know the walk is over once it drops to zero
+type dirQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	dirs    []dirJob
+	pending int
+}
+
+// newDirQueue creates an empty queue ready to be shared between workers
+func newDirQueue() *dirQueue {
+	q := &dirQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a directory to the queue and wakes up one idle worker
+func (q *dirQueue) push(dir dirJob) {
+	q.mu.Lock()
+	q.dirs = append(q.dirs, dir)
+	q.pending++
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// pop blocks until a directory is available
+// It returns false once every directory has been read and nothing is left to do
+func (q *dirQueue) pop() (dirJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.dirs) == 0 && q.pending > 0 {
+		q.cond.Wait()
+	}
+	if len(q.dirs) == 0 {
+		return dirJob{}, false
+	}
+
+	// Take from the end: a depth-first order keeps the queue small
+	last := len(q.dirs) - 1
+	dir := q.dirs[last]
+	q.dirs = q.dirs[:last]
+	return dir, true
+}
+
+// done marks one popped directory as finished
+// When it was the last one, every waiting worker is woken up so they can exit
+func (q *dirQueue) done() {
+	q.mu.Lock()
+	q.pending--
+	finished := q.pending == 0
+	q.mu.Unlock()
+
+	if finished {
+		q.cond.Broadcast()
+	}
+}
+
+// walker searches a directory tree for Git repositories using a fixed number of goroutines
+type walker struct {
+	opts     scanOptions
+	excludes *excludeRules
+	queue    *dirQueue
+
+	// rootDev is the device of the scan root, used by -one-file-system
+	rootDev    uint64
+	hasRootDev bool
+
+	mu      sync.Mutex
+	repos   []foundRepo
+	visited int
+	skipped []scanError
+	seen    map[fileID]bool // directories already read, used to break symlink loops
+}
+
+// newWalker creates a walker that reads at most opts.workers directories at once
+// and never descends into directories matched by excludes
+func newWalker(opts scanOptions, excludes *excludeRules) *walker {
+	if opts.workers < 1 {
+		opts.workers = 1
+	}
+	return &walker{
+		opts:     opts,
+		excludes: excludes,
+		queue:    newDirQueue(),
+		seen:     make(map[fileID]bool),
+	}
+}
+
+// walk scans everything below root and returns the repositories it found
+// The result is sorted so it doesn't depend on which goroutine got there first
+func (w *walker) walk(root string) walkResult {
+	start := time.Now()
+	// Registered paths are always absolute, whatever the user typed after -add
+	root = absPath(root)
+
+	// Remember which filesystem the root lives on so -one-file-system can stop at mount points
+	if info, err := os.Stat(root); err == nil {
+		if id, ok := fileIdentity(info); ok {
+			w.rootDev = id.dev
+			w.hasRootDev = true
+		}
+	}
+
+	w.queue.push(dirJob{path: root})
+
+	var wg sync.WaitGroup
+	for i := 0; i < w.opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.work()
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(w.repos, func(i, j int) bool {
+		return w.repos[i].path < w.repos[j].path
+	})
+
+	sort.Slice(w.skipped, func(i, j int) bool {
+		return w.skipped[i].path < w.skipped[j].path
+	})
+
+	return walkResult{
+		repos:   w.repos,
+		visited: w.visited,
+		elapsed: time.Since(start),
+		skipped: w.skipped,
+	}
+}
+
+// work is the loop run by every worker goroutine
+func (w *walker) work() {
+	for {
+		job, ok := w.queue.pop()
+		if !ok {
+			return
+		}
+		w.readDir(job)
+		w.queue.done()
+	}
+}
+
+// readDir reads a single directory, records it if it is a repository
+// and queues up the subdirectories that aren't excluded
+func (w *walker) readDir(job dirJob) {
+	dir := job.path
+
+	f, err := os.Open(dir)
+	if err != nil {
+		w.fail(dir, err)
+		return
+	}
+
+	// When following symlinks the same directory can be reached twice, or even in a loop
+	// Check its device and inode before reading it again
+	if w.opts.followSymlinks && !w.markSeen(f) {
+		f.Close()
+		return
+	}
+
+	// ReadDir(-1) returns every entry at once, without calling stat on each of them
+	// On error it still returns the entries it managed to read, so we carry on with those
+	entries, err := f.ReadDir(-1)
+	f.Close()
+	if err != nil {
+		w.fail(dir, err)
+	}
+
+	w.mu.Lock()
+	w.visited++
+	w.mu.Unlock()
+
+	// A bare repository only holds git internals, so there is nothing below it worth scanning
+	if isBareRepoDir(entries) {
+		w.addRepo(foundRepo{path: dir, kind: kindBare})
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if name == ".git" {
+			// A .git directory is a normal checkout
+			// A .git file points somewhere else: a linked worktree or a submodule
+			if entry.IsDir() {
+				w.addRepo(foundRepo{path: dir, kind: kindNormal})
+			} else if gitDir, err := readGitFile(filepath.Join(dir, name)); err == nil {
+				w.addRepo(foundRepo{path: dir, kind: kindFromGitDir(gitDir)})
+			} else {
+				w.fail(filepath.Join(dir, name), err)
+			}
+			// Either way, keep scanning the other entries for nested repositories
+			continue
+		}
+
+		if !w.shouldDescend(dir, entry) {
+			continue
+		}
+
+		// Build a fresh slice so sibling jobs never share a backing array
+		rel := make([]string, len(job.rel)+1)
+		copy(rel, job.rel)
+		rel[len(job.rel)] = name
+
+		if w.excludes.excluded(rel) {
+			continue
+		}
+
+		// rel has one component per level below the root, so its length is the depth
+		if w.opts.maxDepth >= 0 && len(rel) > w.opts.maxDepth {
+			continue
+		}
+
+		w.queue.push(dirJob{path: filepath.Join(dir, name), rel: rel})
+	}
+}
+
+// addRepo records a repository; it is called from several goroutines at once
+func (w *walker) addRepo(r foundRepo) {
+	// With symlinks followed, a repository may be reached through a link first or through its
+	// real path first depending on timing; recording the real path keeps the result stable
+	if w.opts.followSymlinks {
+		if real, err := filepath.EvalSymlinks(r.path); err == nil {
+			r.path = real
+		}
+	}
+
+	w.mu.Lock()
+	w.repos = append(w.repos, r)
+	w.mu.Unlock()
+}
+
+// shouldDescend decides whether a directory entry is a directory the walker should enter
+// Symlinks are only followed with -follow-symlinks, and -one-file-system
+// stops at anything living on a different device than the root
+func (w *walker) shouldDescend(dir string, entry os.DirEntry) bool {
+	var info os.FileInfo
+	var err error
+
+	switch {
+	case entry.IsDir():
+		// Only pay for an lstat when we need the device number
+		if !w.opts.oneFileSystem {
+			return true
+		}
+		info, err = entry.Info()
+	case entry.Type()&os.ModeSymlink != 0 && w.opts.followSymlinks:
+		// os.Stat follows the link, so info describes the target
+		info, err = os.Stat(filepath.Join(dir, entry.Name()))
+		if err == nil && !info.IsDir() {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if err != nil {
+		// Broken symlinks and entries removed mid-scan are simply skipped
+		return false
+	}
+
+	if w.opts.oneFileSystem && w.hasRootDev {
+		if id, ok := fileIdentity(info); ok && id.dev != w.rootDev {
+			return false
+		}
+	}
+	return true
+}
+
+// markSeen records an open directory as read
+// It returns false if the directory was already read through another path
+func (w *walker) markSeen(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return true
+	}
+	id, ok := fileIdentity(info)
+	if !ok {
+		return true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.seen[id] {
+		return false
+	}
+	w.seen[id] = true
+	return true
+}
+
+// fail records a path the walker could not read
+// With -strict the first error ends the program, like the scanner used to
+func (w *walker) fail(path string, err error) {
+	if w.opts.strict {
+		log.Fatal(err)
+	}
+
+	w.mu.Lock()
+	w.skipped = append(w.skipped, scanError{path: path, err: err})
+	w.mu.Unlock()
+}